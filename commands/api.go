@@ -7,6 +7,7 @@ import (
 	"github.com/mix-go/xcli/flag"
 	"github.com/mix-go/xcli/process"
 	"github.com/mix-go/xutil/xenv"
+	"hammer-web-api/controllers"
 	"hammer-web-api/di"
 	"hammer-web-api/routes"
 	"os"
@@ -50,12 +51,19 @@ func (t *APICommand) Main() {
 	server.Addr = flag.Match("a", "addr").String(addr)
 	server.Handler = router
 
+	// background workers
+	workerCtx, stopWorker := context.WithCancel(context.Background())
+	go controllers.RunWebhookWorker(workerCtx)
+	go controllers.RunAccountDeletionWorker(workerCtx)
+	go controllers.RunExportWorker(workerCtx)
+
 	// signal
 	ch := make(chan os.Signal)
 	signal.Notify(ch, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-ch
 		logger.Info("Server shutdown")
+		stopWorker()
 		ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
 		if err := server.Shutdown(ctx); err != nil {
 			logger.Errorf("Server shutdown error: %s", err)
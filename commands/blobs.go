@@ -0,0 +1,30 @@
+package commands
+
+import (
+	"fmt"
+	"hammer-web-api/controllers"
+	"hammer-web-api/di"
+)
+
+type BlobsCommand struct {
+}
+
+func (t *BlobsCommand) Main() {
+	logger := di.Zap()
+
+	converted, before, after, err := controllers.MigrateVersionBlobs(di.Gorm())
+	if err != nil {
+		logger.Errorf("Blob migration error after %d versions: %s", converted, err)
+		return
+	}
+
+	saved := before - after
+	ratio := 0.0
+	if before > 0 {
+		ratio = float64(saved) / float64(before) * 100
+	}
+	fmt.Println(fmt.Sprintf("Converted   Versions:  %d", converted))
+	fmt.Println(fmt.Sprintf("Content     Before:    %d bytes", before))
+	fmt.Println(fmt.Sprintf("Content     After:     %d bytes", after))
+	fmt.Println(fmt.Sprintf("Space       Saved:     %d bytes (%.1f%%)", saved, ratio))
+}
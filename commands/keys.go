@@ -0,0 +1,63 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/mix-go/xcli/flag"
+	"hammer-web-api/di"
+	"time"
+)
+
+type KeysCommand struct {
+}
+
+// Main lists the signing keys, or generates or prunes them. A rotation is a generate, the new
+// key signs once it has been published for the jwks cache lifetime, followed by a prune once
+// the window has passed.
+func (t *KeysCommand) Main() {
+	logger := di.Zap()
+	dir := di.JWTKeys().Dir()
+
+	if flag.Match("g", "generate").Bool() {
+		alg := flag.Match("alg").String(di.AlgRS256)
+		publish := time.Duration(flag.Match("publish").Int64(300)) * time.Second
+		window := time.Duration(flag.Match("w", "window").Int64(24)) * time.Hour
+		key, err := di.GenerateJWTKey(dir, alg, publish, window)
+		if err != nil {
+			logger.Errorf("Key generation error: %s", err)
+			return
+		}
+		fmt.Println(fmt.Sprintf("Generated   Key:       %s (%s)", key.Kid, key.Alg))
+		return
+	}
+
+	if flag.Match("p", "prune").Bool() {
+		pruned, err := di.PruneJWTKeys(dir)
+		if err != nil {
+			logger.Errorf("Key pruning error: %s", err)
+			return
+		}
+		fmt.Println(fmt.Sprintf("Pruned      Keys:      %d", len(pruned)))
+		for _, kid := range pruned {
+			fmt.Println(fmt.Sprintf("            Key:       %s", kid))
+		}
+		return
+	}
+
+	keys, current, next, promoteAt, err := di.ListJWTKeys(dir)
+	if err != nil {
+		logger.Errorf("Key listing error: %s", err)
+		return
+	}
+	fmt.Println(fmt.Sprintf("Key         Dir:       %s", dir))
+	for _, key := range keys {
+		state := "active"
+		if key.Kid == current {
+			state = "current"
+		} else if key.Kid == next {
+			state = "signs from " + promoteAt.Format(time.RFC3339)
+		} else if key.RetireAt != nil {
+			state = "retires " + key.RetireAt.Format(time.RFC3339)
+		}
+		fmt.Println(fmt.Sprintf("            Key:       %s %s %s", key.Kid, key.Alg, state))
+	}
+}
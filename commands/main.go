@@ -20,4 +20,51 @@ var Commands = []*xcli.Command{
 		},
 		RunI: &APICommand{},
 	},
+	{
+		Name:  "blobs",
+		Short: "\tConvert textbook versions into content-addressed blobs",
+		RunI:  &BlobsCommand{},
+	},
+	{
+		Name:  "revoke",
+		Short: "\tRevoke the tokens of a user or a single access token",
+		Options: []*xcli.Option{
+			{
+				Names: []string{"u", "user"},
+				Usage: "\tRevoke every token of the user id",
+			},
+			{
+				Names: []string{"t", "token"},
+				Usage: "\tRevoke a single access token",
+			},
+		},
+		RunI: &RevokeCommand{},
+	},
+	{
+		Name:  "keys",
+		Short: "\tGenerate, rotate and prune the JWT signing keys",
+		Options: []*xcli.Option{
+			{
+				Names: []string{"g", "generate"},
+				Usage: "\tGenerate a key and sign new tokens with it once published",
+			},
+			{
+				Names: []string{"alg"},
+				Usage: "\tAlgorithm of the generated key, RS256 or EdDSA (default RS256)",
+			},
+			{
+				Names: []string{"publish"},
+				Usage: "\tSeconds the key is published before it signs tokens (default 300)",
+			},
+			{
+				Names: []string{"w", "window"},
+				Usage: "\tHours the previous key keeps verifying tokens (default 24)",
+			},
+			{
+				Names: []string{"p", "prune"},
+				Usage: "\tRemove keys past their retirement",
+			},
+		},
+		RunI: &KeysCommand{},
+	},
 }
@@ -0,0 +1,57 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/mix-go/xcli/flag"
+	"hammer-web-api/di"
+	"hammer-web-api/middleware"
+	"strconv"
+)
+
+type RevokeCommand struct {
+}
+
+// Main revokes every token of a user or a single access token, for operators
+// responding to a leaked token or a compromised account
+func (t *RevokeCommand) Main() {
+	logger := di.Zap()
+	ctx := context.Background()
+
+	if user := flag.Match("u", "user").String(); user != "" {
+		uid, err := strconv.ParseUint(user, 10, 0)
+		if err != nil {
+			logger.Errorf("Invalid user id: %s", user)
+			return
+		}
+		if err = di.RevokeUserTokens(ctx, uint(uid)); err != nil {
+			logger.Errorf("Token revocation error: %s", err)
+			return
+		}
+		fmt.Println(fmt.Sprintf("Revoked     User:      %d", uid))
+		return
+	}
+
+	if raw := flag.Match("t", "token").String(); raw != "" {
+		// the token is revoked whether or not its signature checks out
+		claims := &middleware.Claims{}
+		if _, _, err := jwt.NewParser().ParseUnverified(raw, claims); err != nil {
+			logger.Errorf("Invalid token: %s", err)
+			return
+		}
+		jti := claims.ID
+		if jti == "" || claims.ExpiresAt == nil {
+			logger.Error("Token has no jti, revoke its user instead")
+			return
+		}
+		if err := di.DenyToken(ctx, jti, claims.ExpiresAt.Time); err != nil {
+			logger.Errorf("Token revocation error: %s", err)
+			return
+		}
+		fmt.Println(fmt.Sprintf("Revoked     Token:     %s", jti))
+		return
+	}
+
+	logger.Error("Please specify --user or --token")
+}
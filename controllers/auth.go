@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"hammer-web-api/di"
+	"hammer-web-api/middleware"
+	"hammer-web-api/models"
+	"net/http"
+	"time"
+)
+
+type AuthController struct {
+}
+
+type refreshForm struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// Refresh exchanges a refresh token for a new access token and the next refresh token,
+// the presented one cannot be used again
+func (t *AuthController) Refresh(c *gin.Context) {
+	rf := refreshForm{}
+	if err := c.ShouldBindJSON(&rf); err != nil {
+		di.Zap().Errorf("failed to bind form: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+		return
+	}
+
+	uid, refreshToken, err := di.RotateRefreshToken(c.Request.Context(), rf.RefreshToken)
+	if errors.Is(err, di.ErrRefreshReused) {
+		di.Zap().Warnf("refresh token of user %d was reused, its family is revoked", uid)
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "refresh token has already been used, please log in again"})
+		return
+	}
+	if errors.Is(err, di.ErrRefreshInvalid) {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "invalid refresh token"})
+		return
+	}
+	if err != nil {
+		di.Zap().Errorf("failed to rotate refresh token: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	// deleted accounts cannot come back through an old session, roles are read again for the new claims
+	user := models.User{}
+	if di.Gorm().Select("id", "roles").First(&user, uid).RowsAffected == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "invalid refresh token"})
+		return
+	}
+	token, err := generateToken(&user)
+	if err != nil {
+		di.Zap().Errorf("failed to create token: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to create token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "OK",
+		"accessToken":  token,
+		"expireIn":     int(accessTokenTTL().Seconds()),
+		"refreshToken": refreshToken,
+	})
+}
+
+type logoutForm struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// Logout revokes the access token of the request and, when given, its refresh token
+func (t *AuthController) Logout(c *gin.Context) {
+	lf := logoutForm{}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&lf); err != nil {
+			di.Zap().Errorf("failed to bind form: %s", err)
+			c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+			return
+		}
+	}
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "failed to get principal"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	if principal.TokenID != "" {
+		if err := di.DenyToken(ctx, principal.TokenID, principal.ExpiresAt); err != nil {
+			di.Zap().Errorf("failed to deny token: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+			return
+		}
+	}
+	if lf.RefreshToken != "" {
+		if err := di.RevokeRefreshToken(ctx, lf.RefreshToken); err != nil {
+			di.Zap().Errorf("failed to revoke refresh token: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+	})
+}
+
+// LogoutAll signs the current user out of every session
+func (t *AuthController) LogoutAll(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	if err := di.RevokeUserTokens(c.Request.Context(), userID); err != nil {
+		di.Zap().Errorf("failed to revoke tokens of user %d: %s", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+	})
+}
+
+type revokeForm struct {
+	UserID  uint   `json:"userID"`
+	TokenID string `json:"jti" binding:"max=64"`
+}
+
+// Revoke lets administrators revoke every token of a user or a single access token by its jti,
+// like the revoke command does for operators
+func (t *AuthController) Revoke(c *gin.Context) {
+	rf := revokeForm{}
+	if err := c.ShouldBindJSON(&rf); err != nil {
+		di.Zap().Errorf("failed to bind form: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+		return
+	}
+	if (rf.UserID == 0) == (rf.TokenID == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please specify either userID or jti"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	if rf.UserID != 0 {
+		if err := di.RevokeUserTokens(ctx, rf.UserID); err != nil {
+			di.Zap().Errorf("failed to revoke tokens of user %d: %s", rf.UserID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+			return
+		}
+	} else {
+		// no access token outlives accessTokenTTL, the denial may as well last that long
+		if err := di.DenyToken(ctx, rf.TokenID, time.Now().Add(accessTokenTTL())); err != nil {
+			di.Zap().Errorf("failed to deny token: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+	})
+}
+
+// JWKS publishes the public keys tokens are signed with, keys being rotated out stay
+// listed until they retire
+func (t *AuthController) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, di.JWTKeys().JWKS())
+}
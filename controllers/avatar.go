@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/mix-go/xcli"
+	"github.com/mix-go/xutil/xenv"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"hammer-web-api/di"
+	"hammer-web-api/models"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// avatarSizes are the square edge lengths generated for every upload,
+// the first one is stored in User.Avatar and the last one is the thumbnail
+var avatarSizes = []int{512, 256, 64}
+
+const (
+	avatarMaxBytes = 5 << 20
+	// a small file may still declare huge dimensions, decoding allocates width*height pixels
+	avatarMaxPixels = 4096 * 4096
+)
+
+var (
+	errUnsupportedImage = errors.New("unsupported image format")
+	errImageTooLarge    = errors.New("image dimensions are too large")
+)
+
+func (t *UserController) PostAvatar(c *gin.Context) {
+	// only the owner may change the avatar
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	if c.Param("id") != fmt.Sprint(userID) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return
+	}
+
+	// read upload
+	fh, err := c.FormFile("avatar")
+	if err != nil {
+		di.Zap().Errorf("failed to get avatar file: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please upload an image in field avatar"})
+		return
+	}
+	if fh.Size > avatarMaxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": "image is too large"})
+		return
+	}
+	file, err := fh.Open()
+	if err != nil {
+		di.Zap().Errorf("failed to open avatar file: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	defer file.Close()
+
+	// decode, re-encoding drops all EXIF data
+	src, err := decodeAvatar(io.LimitReader(file, avatarMaxBytes))
+	if errors.Is(err, errImageTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": "image must not exceed 4096x4096 pixels"})
+		return
+	}
+	if err != nil {
+		di.Zap().Errorf("failed to decode avatar: %s", err)
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"message": "only png, jpeg and webp are supported"})
+		return
+	}
+
+	// crop, resize and store every size
+	dir := filepath.Join(UploadDir(), "avatars", fmt.Sprint(userID))
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		di.Zap().Errorf("failed to create avatar dir %s: %s", dir, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	stamp := strconv.FormatInt(time.Now().UnixNano(), 36)
+	urls := gin.H{}
+	for _, size := range avatarSizes {
+		name := fmt.Sprintf("%s_%d.jpg", stamp, size)
+		if err = writeAvatar(filepath.Join(dir, name), resizeAvatar(src, size)); err != nil {
+			di.Zap().Errorf("failed to write avatar %s: %s", name, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+			return
+		}
+		urls[strconv.Itoa(size)] = fmt.Sprintf("%s/uploads/avatars/%v/%s", appURL(), userID, name)
+	}
+
+	// update user
+	avatar := urls[strconv.Itoa(avatarSizes[0])]
+	res := di.Gorm().Model(&models.User{}).Where("id = ?", userID).Update("avatar", avatar)
+	if res.Error != nil {
+		di.Zap().Errorf("failed to update avatar of user %v: %s", userID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "ok",
+		"data": gin.H{
+			"avatar": avatar,
+			"sizes":  urls,
+		},
+	})
+}
+
+// decodeAvatar decodes png, jpeg and webp images of at most avatarMaxPixels only
+func decodeAvatar(r io.Reader) (image.Image, error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	if format != "png" && format != "jpeg" && format != "webp" {
+		return nil, errUnsupportedImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > avatarMaxPixels {
+		return nil, errImageTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(buf))
+	return img, err
+}
+
+// resizeAvatar center-crops src to a square and scales it to size x size
+func resizeAvatar(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	edge := b.Dx()
+	if b.Dy() < edge {
+		edge = b.Dy()
+	}
+	x0 := b.Min.X + (b.Dx()-edge)/2
+	y0 := b.Min.Y + (b.Dy()-edge)/2
+	crop := image.Rect(x0, y0, x0+edge, y0+edge)
+
+	// jpeg has no alpha channel, so transparent pixels become white
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Over, nil)
+	return dst
+}
+
+func writeAvatar(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = jpeg.Encode(f, img, &jpeg.Options{Quality: 85}); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// UploadDir is where user uploaded files are stored and served from
+func UploadDir() string {
+	return xenv.Getenv("UPLOAD_DIR").String(fmt.Sprintf("%s/../runtime/uploads", xcli.App().BasePath))
+}
+
+// appURL is the public base url used to build absolute links
+func appURL() string {
+	return xenv.Getenv("APP_URL").String("http://127.0.0.1:8080")
+}
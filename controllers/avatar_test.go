@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// pngHeader is the start of a png declaring width x height, enough for image.DecodeConfig
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], width)
+	binary.BigEndian.PutUint32(ihdr[8:], height)
+	ihdr[12], ihdr[13] = 8, 6 // 8 bit rgba
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(13))
+	buf.Write(ihdr)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(ihdr))
+	return buf.Bytes()
+}
+
+func TestDecodeAvatar(t *testing.T) {
+	if _, err := decodeAvatar(bytes.NewReader(pngHeader(30000, 30000))); !errors.Is(err, errImageTooLarge) {
+		t.Errorf("30000x30000 png: %v", err)
+	}
+	if _, err := decodeAvatar(bytes.NewReader(pngHeader(4097, 4096))); !errors.Is(err, errImageTooLarge) {
+		t.Errorf("4097x4096 png: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 4))); err != nil {
+		t.Fatal(err)
+	}
+	img, err := decodeAvatar(&buf)
+	if err != nil || img.Bounds().Dx() != 8 {
+		t.Errorf("8x4 png: %v", err)
+	}
+
+	if _, err = decodeAvatar(bytes.NewReader([]byte("GIF89a"))); err == nil {
+		t.Error("gif was accepted")
+	}
+}
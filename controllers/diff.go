@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	diffEqual  = ' '
+	diffDelete = '-'
+	diffInsert = '+'
+
+	// diffMaxEdits bounds the edit distance myers searches, its trace grows with the square of it
+	diffMaxEdits = 1000
+)
+
+type diffOp struct {
+	Kind byte
+	Line string
+}
+
+// diffLines computes the shortest edit script turning a into b with the Myers algorithm
+func diffLines(a, b []string) []diffOp {
+	// common prefix and suffix never take part in the edit script
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, l := range a[:pre] {
+		ops = append(ops, diffOp{diffEqual, l})
+	}
+	ops = append(ops, myers(a[pre:len(a)-suf], b[pre:len(b)-suf])...)
+	for _, l := range a[len(a)-suf:] {
+		ops = append(ops, diffOp{diffEqual, l})
+	}
+	return ops
+}
+
+// myers finds the shortest edit script, inputs further apart than diffMaxEdits are
+// reported as deleted and inserted as a whole
+func myers(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+	if max > diffMaxEdits {
+		max = diffMaxEdits
+	}
+	offset := max + 1
+	v := make([]int, 2*max+3)
+
+	// trace[d] keeps the furthest x of every diagonal reached after d-1 edits
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		snap := make([]int, 2*d+3)
+		copy(snap, v[offset-d-1:offset+d+2])
+		trace = append(trace, snap)
+
+		found := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+		if found {
+			break
+		}
+		if d == max {
+			return replaceLines(a, b)
+		}
+	}
+
+	// walk the trace backwards from (n, m)
+	ops := make([]diffOp, 0, n+m)
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		snap := trace[d]
+		at := func(k int) int { return snap[k+d+1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{diffEqual, a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{diffInsert, b[y-1]})
+			} else {
+				ops = append(ops, diffOp{diffDelete, a[x-1]})
+			}
+			x, y = prevX, prevY
+		}
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+func replaceLines(a, b []string) []diffOp {
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, l := range a {
+		ops = append(ops, diffOp{diffDelete, l})
+	}
+	for _, l := range b {
+		ops = append(ops, diffOp{diffInsert, l})
+	}
+	return ops
+}
+
+// unifiedDiff renders the line diff of a and b as unified diff hunks with context lines around each change
+func unifiedDiff(a, b string, context int) string {
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	// line numbers in a and b before every op
+	aLine := make([]int, len(ops)+1)
+	bLine := make([]int, len(ops)+1)
+	for i, op := range ops {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if op.Kind != diffInsert {
+			aLine[i+1]++
+		}
+		if op.Kind != diffDelete {
+			bLine[i+1]++
+		}
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == diffEqual {
+			i++
+			continue
+		}
+		// extend the hunk while changes are close enough to share context
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end, gap := i, 0
+		for j := i; j < len(ops) && gap <= 2*context; j++ {
+			if ops[j].Kind == diffEqual {
+				gap++
+			} else {
+				gap = 0
+				end = j
+			}
+		}
+		end += context + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		_, _ = fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n",
+			aLine[start]+1, aLine[end]-aLine[start], bLine[start]+1, bLine[end]-bLine[start])
+		for _, op := range ops[start:end] {
+			sb.WriteByte(op.Kind)
+			sb.WriteString(op.Line)
+			sb.WriteByte('\n')
+		}
+		i = end
+	}
+	return sb.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// diffHunk replaces base[start:end] with lines
+type diffHunk struct {
+	start, end int
+	lines      []string
+}
+
+func diffHunks(ops []diffOp) []diffHunk {
+	var hunks []diffHunk
+	pos := 0
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == diffEqual {
+			pos++
+			i++
+			continue
+		}
+		h := diffHunk{start: pos, end: pos}
+		for ; i < len(ops) && ops[i].Kind != diffEqual; i++ {
+			if ops[i].Kind == diffDelete {
+				h.end++
+			} else {
+				h.lines = append(h.lines, ops[i].Line)
+			}
+		}
+		pos = h.end
+		hunks = append(hunks, h)
+	}
+	return hunks
+}
+
+// merge3 applies the changes from base to ours and from base to theirs on top of each other,
+// it fails when both sides changed the same or adjacent lines differently
+func merge3(base, ours, theirs string) (string, bool) {
+	b := splitLines(base)
+	a := diffHunks(diffLines(b, splitLines(ours)))
+	t := diffHunks(diffLines(b, splitLines(theirs)))
+
+	apply := func(hunks []diffHunk, start, end int) []string {
+		var out []string
+		p := start
+		for _, h := range hunks {
+			out = append(out, b[p:h.start]...)
+			out = append(out, h.lines...)
+			p = h.end
+		}
+		return append(out, b[p:end]...)
+	}
+
+	var out []string
+	pos, i, j := 0, 0, 0
+	for i < len(a) || j < len(t) {
+		// collect every hunk of both sides that overlaps or touches the group
+		fromA, fromT := i, j
+		var start, end int
+		if j >= len(t) || (i < len(a) && a[i].start <= t[j].start) {
+			start, end = a[i].start, a[i].end
+			i++
+		} else {
+			start, end = t[j].start, t[j].end
+			j++
+		}
+		for {
+			if i < len(a) && a[i].start <= end {
+				if a[i].end > end {
+					end = a[i].end
+				}
+				i++
+			} else if j < len(t) && t[j].start <= end {
+				if t[j].end > end {
+					end = t[j].end
+				}
+				j++
+			} else {
+				break
+			}
+		}
+
+		out = append(out, b[pos:start]...)
+		switch {
+		case fromT == j:
+			out = append(out, apply(a[fromA:i], start, end)...)
+		case fromA == i:
+			out = append(out, apply(t[fromT:j], start, end)...)
+		default:
+			mine, other := apply(a[fromA:i], start, end), apply(t[fromT:j], start, end)
+			if strings.Join(mine, "\n") != strings.Join(other, "\n") {
+				return "", false
+			}
+			out = append(out, mine...)
+		}
+		pos = end
+	}
+	out = append(out, b[pos:]...)
+	return strings.Join(out, "\n"), true
+}
@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	cases := []struct {
+		a, b string
+	}{
+		{"", ""},
+		{"a\nb\nc", "a\nb\nc"},
+		{"", "a\nb"},
+		{"a\nb", ""},
+		{"a\nb\nc\nd", "a\nx\nc\nd\ne"},
+		{"a\nb\nc\na\nb\nb\na", "c\nb\na\nb\na\nc"},
+	}
+	for _, tc := range cases {
+		ops := diffLines(splitLines(tc.a), splitLines(tc.b))
+		var a, b []string
+		for _, op := range ops {
+			if op.Kind != diffInsert {
+				a = append(a, op.Line)
+			}
+			if op.Kind != diffDelete {
+				b = append(b, op.Line)
+			}
+		}
+		if strings.Join(a, "\n") != tc.a || strings.Join(b, "\n") != tc.b {
+			t.Errorf("diff of %q and %q does not reproduce both sides: %v", tc.a, tc.b, ops)
+		}
+	}
+}
+
+func TestDiffLinesIsMinimal(t *testing.T) {
+	// the classic example from the Myers paper has an edit distance of 5
+	ops := diffLines(strings.Split("abcabba", ""), strings.Split("cbabac", ""))
+	edits := 0
+	for _, op := range ops {
+		if op.Kind != diffEqual {
+			edits++
+		}
+	}
+	if edits != 5 {
+		t.Errorf("expected 5 edits, got %d: %v", edits, ops)
+	}
+}
+
+func TestDiffLinesFallsBackToReplace(t *testing.T) {
+	a := make([]string, diffMaxEdits)
+	b := make([]string, diffMaxEdits)
+	for i := range a {
+		a[i] = fmt.Sprintf("a%d", i)
+		b[i] = fmt.Sprintf("b%d", i)
+	}
+	b[diffMaxEdits/2] = a[diffMaxEdits/2]
+	ops := diffLines(a, b)
+	if len(ops) != 2*diffMaxEdits {
+		t.Fatalf("expected %d ops, got %d", 2*diffMaxEdits, len(ops))
+	}
+	if ops[0].Kind != diffDelete || ops[len(ops)-1].Kind != diffInsert {
+		t.Errorf("expected a whole file replace, got %v and %v", ops[0], ops[len(ops)-1])
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10"
+	b := "1\n2\n3\n4\nfive\n6\n7\n8\n9\n10"
+	want := "@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n"
+	if got := unifiedDiff(a, b, 3); got != want {
+		t.Errorf("unexpected diff:\n%s", got)
+	}
+	if got := unifiedDiff(a, a, 3); got != "" {
+		t.Errorf("expected no hunks for equal input, got:\n%s", got)
+	}
+}
+
+func TestMerge3(t *testing.T) {
+	base := "# title\n\nintro\n\n## a\ntext a\n\n## b\ntext b"
+	cases := []struct {
+		name         string
+		ours, theirs string
+		want         string
+		ok           bool
+	}{
+		{
+			name:   "different sections",
+			ours:   "# title\n\nintro changed\n\n## a\ntext a\n\n## b\ntext b",
+			theirs: "# title\n\nintro\n\n## a\ntext a\n\n## b\ntext b changed\nmore b",
+			want:   "# title\n\nintro changed\n\n## a\ntext a\n\n## b\ntext b changed\nmore b",
+			ok:     true,
+		},
+		{
+			name:   "same change on both sides",
+			ours:   "# title\n\nintro\n\n## a\ntext A\n\n## b\ntext b",
+			theirs: "# title\n\nintro\n\n## a\ntext A\n\n## b\ntext b",
+			want:   "# title\n\nintro\n\n## a\ntext A\n\n## b\ntext b",
+			ok:     true,
+		},
+		{
+			name:   "conflicting change",
+			ours:   "# title\n\nintro\n\n## a\ntext 1\n\n## b\ntext b",
+			theirs: "# title\n\nintro\n\n## a\ntext 2\n\n## b\ntext b",
+			ok:     false,
+		},
+		{
+			name:   "one side unchanged",
+			ours:   base,
+			theirs: "# new title\n\nintro\n\n## a\ntext a\n\n## b\ntext b",
+			want:   "# new title\n\nintro\n\n## a\ntext a\n\n## b\ntext b",
+			ok:     true,
+		},
+	}
+	for _, tc := range cases {
+		got, ok := merge3(base, tc.ours, tc.theirs)
+		if ok != tc.ok {
+			t.Errorf("%s: expected ok=%v, got %v", tc.name, tc.ok, ok)
+			continue
+		}
+		if ok && got != tc.want {
+			t.Errorf("%s: unexpected merge result:\n%s", tc.name, got)
+		}
+	}
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	base := "# go\n\n## install\ngo get\n\n## build\ngo build\n"
+	cases := []string{
+		base,
+		"",
+		"# go\n\n## install\ngo install\n\n## build\ngo build\n\n## test\ngo test\n",
+		"completely\ndifferent",
+	}
+	for _, content := range cases {
+		delta, err := encodeDelta(base, content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := applyDelta(base, delta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != content {
+			t.Errorf("delta round trip of %q gave %q", content, got)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+	"hammer-web-api/di"
+	"hammer-web-api/middleware"
+	"hammer-web-api/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type draftCommitForm struct {
+	No    string `json:"no"`
+	Force bool   `json:"force"`
+}
+
+// draftMessage is what clients send over the draft socket
+type draftMessage struct {
+	Type     string          `json:"type"`
+	Rev      int             `json:"rev"`
+	Op       textOp          `json:"op"`
+	Presence json.RawMessage `json:"presence"`
+}
+
+func (t *TextbookController) GetDraft(c *gin.Context) {
+	textbook, _, ok := loadDraftTextbook(c)
+	if !ok {
+		return
+	}
+
+	state, err := loadDraftState(c.Request.Context(), textbook.ID)
+	if err != nil {
+		di.Zap().Errorf("failed to load draft of textbook %d: %s", textbook.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	presence, _ := draftPresences(c.Request.Context(), textbook.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data": gin.H{
+			"rev":           state.Rev,
+			"content":       state.Content,
+			"baseVersionID": state.BaseVersionID,
+			"presence":      presence,
+		},
+	})
+}
+
+// DraftSocket joins the collaborative editing session of a textbook draft.
+// Clients send {"type":"op","rev":n,"op":[...]} with operations in the ot.js format and
+// {"type":"presence","presence":{...}} for cursors, they receive an init snapshot followed
+// by acks of their own ops, ops of others, presence, join, leave and committed messages.
+func (t *TextbookController) DraftSocket(c *gin.Context) {
+	textbook, userID, ok := loadDraftTextbook(c)
+	if !ok {
+		return
+	}
+	principal, _ := middleware.CurrentPrincipal(c)
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		di.Zap().Errorf("failed to upgrade websocket: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := newDraftClient(conn, userID, cancel)
+	if err = drafts.join(textbook.ID, client); err != nil {
+		di.Zap().Errorf("failed to join draft of textbook %d: %s", textbook.ID, err)
+		return
+	}
+	defer drafts.leave(textbook.ID, client)
+
+	state, err := loadDraftState(ctx, textbook.ID)
+	if err == nil {
+		touchDraftPresence(ctx, textbook.ID, client, nil)
+		err = publishDraft(ctx, textbook.ID, draftEntry{Type: "join", UserID: userID, ClientID: client.id})
+	}
+	if err != nil {
+		di.Zap().Errorf("failed to load draft of textbook %d: %s", textbook.ID, err)
+		return
+	}
+	presence, _ := draftPresences(ctx, textbook.ID)
+	client.start(gin.H{
+		"type":          "init",
+		"clientID":      client.id,
+		"rev":           state.Rev,
+		"content":       state.Content,
+		"baseVersionID": state.BaseVersionID,
+		"presence":      presence,
+	}, state.Rev)
+
+	heartbeat := eventHeartbeat()
+	go func() {
+		defer cancel()
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-client.send:
+				conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				if conn.WriteMessage(websocket.TextMessage, msg) != nil {
+					return
+				}
+			case <-ticker.C:
+				if !principalActive(ctx, principal) {
+					return
+				}
+				// the author may have replaced the collaborator since the upgrade
+				if allowed, err := draftAllowed(ctx, textbook.ID, userID); err == nil && !allowed {
+					conn.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "request no permission"),
+						time.Now().Add(10*time.Second))
+					return
+				}
+				if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)) != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	conn.SetReadLimit(1 << 20)
+	conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+	})
+	for ctx.Err() == nil {
+		var msg draftMessage
+		if err = conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+		switch msg.Type {
+		case "op":
+			// the ack arrives through the channel, in order with the ops of others
+			_, err = submitDraftOp(ctx, textbook.ID, msg.Rev, msg.Op, userID, client.id)
+			if err != nil {
+				reload := errors.Is(err, errDraftRevision) || errors.Is(err, errOpBaseLength)
+				message := err.Error()
+				if !reload && !errors.Is(err, errDraftBusy) {
+					di.Zap().Errorf("failed to submit op to draft of textbook %d: %s", textbook.ID, err)
+					message = "internal server error"
+				}
+				client.deliver(draftEntry{Type: "error", Data: gin.H{"message": message, "reload": reload}})
+			}
+		case "presence":
+			if len(msg.Presence) > draftPresenceLimit {
+				continue
+			}
+			touchDraftPresence(ctx, textbook.ID, client, msg.Presence)
+			publishDraft(ctx, textbook.ID, draftEntry{
+				Type: "presence", Rev: msg.Rev, UserID: userID, ClientID: client.id, Presence: msg.Presence,
+			})
+		}
+	}
+}
+
+func (t *TextbookController) CommitDraft(c *gin.Context) {
+	textbook, userID, ok := loadDraftTextbook(c)
+	if !ok {
+		return
+	}
+	df := draftCommitForm{}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&df); err != nil {
+			di.Zap().Errorf("failed to bind form: %s", err)
+			c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+			return
+		}
+	}
+	ctx := c.Request.Context()
+
+	state, err := loadDraftState(ctx, textbook.ID)
+	var latest models.TextbookVersion
+	if err == nil {
+		err = di.Gorm().Where("textbook_id = ?", textbook.ID).Order("created_at DESC").First(&latest).Error
+	}
+	if err == nil {
+		err = loadVersionContent(di.Gorm(), &latest)
+	}
+	if err != nil {
+		di.Zap().Errorf("failed to load draft of textbook %d: %s", textbook.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	// versions published since the draft started are merged into it first
+	content := state.Content
+	if latest.ID != state.BaseVersionID && !df.Force {
+		base := models.TextbookVersion{}
+		if err = di.Gorm().Where("id = ? AND textbook_id = ?", state.BaseVersionID, textbook.ID).First(&base).Error; err == nil {
+			err = loadVersionContent(di.Gorm(), &base)
+		}
+		if err != nil {
+			di.Zap().Errorf("failed to get base version of draft %d: %s", textbook.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+			return
+		}
+		merged, clean := merge3(base.Content, latest.Content, state.Content)
+		if !clean {
+			c.JSON(http.StatusConflict, gin.H{
+				"message": "textbook has been modified, resolve the draft or commit with force",
+				"data": gin.H{
+					"latestVersion": latest.No,
+					"diff":          unifiedDiff(latest.Content, state.Content, 3),
+				},
+			})
+			return
+		}
+		if merged != state.Content {
+			if _, err = submitDraftOp(ctx, textbook.ID, state.Rev, replaceOp(state.Content, merged), userID, draftServerPeer); err != nil {
+				c.JSON(http.StatusConflict, gin.H{"message": "draft changed while committing, please retry"})
+				return
+			}
+		}
+		content = merged
+	}
+	if content == latest.Content {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "draft has no changes"})
+		return
+	}
+
+	version := models.TextbookVersion{
+		No:         df.No,
+		Content:    content,
+		TextbookID: textbook.ID,
+	}
+	if version.No == "" {
+		version.No = nextVersionNo(latest.No)
+	}
+	err = di.Gorm().Transaction(func(tx *gorm.DB) error {
+		var current models.TextbookVersion
+		if err := latestVersionLocked(tx, textbook.ID, &current); err != nil {
+			return err
+		}
+		if current.ID != latest.ID {
+			return errVersionConflict
+		}
+		return saveVersion(tx, &version)
+	})
+	if errors.Is(err, errVersionConflict) {
+		c.JSON(http.StatusConflict, gin.H{"message": "textbook has been modified, please retry"})
+		return
+	}
+	if errors.Is(err, models.ErrVersionFormat) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid version format"})
+		return
+	}
+	if err != nil {
+		di.Zap().Errorf("failed to commit draft of textbook %d: %s", textbook.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	// the draft goes on from the committed version
+	if err = di.GoRedis().HSet(ctx, draftStateKey(textbook.ID), "version", version.ID).Err(); err == nil {
+		err = persistDraft(ctx, textbook.ID)
+	}
+	if err != nil {
+		di.Zap().Errorf("failed to rebase draft of textbook %d: %s", textbook.ID, err)
+	}
+	publishDraft(ctx, textbook.ID, draftEntry{Type: "committed", UserID: userID, Data: gin.H{"vid": version.ID, "version": version.No}})
+
+	textbookCache.Invalidate(context.Background(), textbook.ID)
+	notifyNewVersion(textbook.ID, version.ID, userID, version.No)
+	emitWebhookEvent(models.WebhookVersionPublished, textbook, gin.H{"vid": version.ID, "version": version.No, "draft": true})
+	publishCollaboratorActivity(textbook, userID, "draft.committed", gin.H{"vid": version.ID, "version": version.No})
+	recordTextbookActivity(textbook, &version)
+
+	c.Header("ETag", versionETag(version.ID))
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "OK",
+		"data": gin.H{
+			"vid":     version.ID,
+			"version": version.No,
+		},
+	})
+}
+
+// loadDraftTextbook loads the textbook of the route, drafts are open to its author and collaborator
+func loadDraftTextbook(c *gin.Context) (models.Textbook, uint, bool) {
+	var textbook models.Textbook
+	userID, ok := currentUserID(c)
+	if !ok {
+		return textbook, 0, false
+	}
+	tid, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid textbook id"})
+		return textbook, 0, false
+	}
+	res := di.Gorm().First(&textbook, tid)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("textbook %d not found", tid)})
+		} else {
+			di.Zap().Errorf("failed to query textbook: %s", res.Error)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		}
+		return textbook, 0, false
+	}
+	if textbook.AuthorID != userID && (textbook.CollaboratorID == nil || *textbook.CollaboratorID != userID) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return textbook, 0, false
+	}
+	return textbook, userID, true
+}
+
+// draftAllowed tells whether userID may still edit the draft of tid
+func draftAllowed(ctx context.Context, tid, userID uint) (bool, error) {
+	var textbook models.Textbook
+	res := di.Gorm().WithContext(ctx).Select("id", "author_id", "collaborator_id").Limit(1).Find(&textbook, tid)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return false, nil
+	}
+	return textbook.AuthorID == userID || (textbook.CollaboratorID != nil && *textbook.CollaboratorID == userID), nil
+}
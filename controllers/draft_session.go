@@ -0,0 +1,285 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/mix-go/xutil/xenv"
+	"github.com/redis/go-redis/v9"
+	"hammer-web-api/di"
+	"sync"
+	"time"
+)
+
+const (
+	draftSendBuffer    = 256
+	draftPresenceLimit = 1024
+	draftPresenceStale = time.Minute
+)
+
+// draftHub holds the rooms of the drafts edited through this instance, a room relays
+// the draft channel to its local clients and persists the draft while anyone is connected
+type draftHub struct {
+	mu    sync.Mutex
+	rooms map[uint]*draftRoom
+}
+
+type draftRoom struct {
+	tid     uint
+	clients map[*draftClient]struct{}
+	cancel  context.CancelFunc
+}
+
+var drafts = &draftHub{rooms: make(map[uint]*draftRoom)}
+
+// draftClient is one websocket connection, messages relayed before the client
+// received its snapshot are held back and filtered by revision
+type draftClient struct {
+	id     string
+	userID uint
+	conn   *websocket.Conn
+	send   chan []byte
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	ready   bool
+	since   int
+	backlog []draftEntry
+	closed  bool
+}
+
+func newDraftClient(conn *websocket.Conn, userID uint, cancel context.CancelFunc) *draftClient {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return &draftClient{
+		id:     hex.EncodeToString(b),
+		userID: userID,
+		conn:   conn,
+		send:   make(chan []byte, draftSendBuffer),
+		cancel: cancel,
+	}
+}
+
+// join adds c to the room of tid, the room subscribes before returning so
+// no message published after join is missed
+func (h *draftHub) join(tid uint, c *draftClient) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if room, ok := h.rooms[tid]; ok {
+		room.clients[c] = struct{}{}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ps := di.GoRedis().Subscribe(ctx, draftChannel(tid))
+	if _, err := ps.Receive(ctx); err != nil {
+		cancel()
+		ps.Close()
+		return err
+	}
+	room := &draftRoom{tid: tid, clients: map[*draftClient]struct{}{c: {}}, cancel: cancel}
+	h.rooms[tid] = room
+	go h.relay(ctx, room, ps.Channel())
+	go h.persist(ctx, room)
+	go func() {
+		<-ctx.Done()
+		ps.Close()
+	}()
+	return nil
+}
+
+func (h *draftHub) leave(tid uint, c *draftClient) {
+	h.mu.Lock()
+	room, ok := h.rooms[tid]
+	if ok {
+		delete(room.clients, c)
+		if len(room.clients) == 0 {
+			delete(h.rooms, tid)
+			room.cancel()
+		}
+	}
+	h.mu.Unlock()
+
+	ctx := context.Background()
+	di.GoRedis().HDel(ctx, draftPresenceKey(tid), c.id)
+	publishDraft(ctx, tid, draftEntry{Type: "leave", UserID: c.userID, ClientID: c.id})
+	if ok && len(room.clients) == 0 {
+		if err := persistDraft(ctx, tid); err != nil {
+			di.Zap().Errorf("failed to persist draft of textbook %d: %s", tid, err)
+		}
+	}
+}
+
+func (h *draftHub) clients(room *draftRoom) []*draftClient {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	clients := make([]*draftClient, 0, len(room.clients))
+	for c := range room.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+func (h *draftHub) relay(ctx context.Context, room *draftRoom, ch <-chan *redis.Message) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var e draftEntry
+			if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+				di.Zap().Errorf("failed to decode draft message: %s", err)
+				continue
+			}
+			for _, c := range h.clients(room) {
+				if e.Type == "revoked" {
+					if c.userID == e.UserID {
+						c.revoke()
+					}
+					continue
+				}
+				c.deliver(e)
+			}
+		}
+	}
+}
+
+// persist saves the draft periodically and keeps the presence of local clients fresh
+func (h *draftHub) persist(ctx context.Context, room *draftRoom) {
+	interval := time.Duration(xenv.Getenv("DRAFT_PERSIST_INTERVAL").Int64(10)) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	persisted := -1
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, c := range h.clients(room) {
+			touchDraftPresence(ctx, room.tid, c, nil)
+		}
+		rev, err := draftRevision(ctx, room.tid)
+		if err != nil || rev == persisted {
+			continue
+		}
+		if err = persistDraft(ctx, room.tid); err != nil {
+			di.Zap().Errorf("failed to persist draft of textbook %d: %s", room.tid, err)
+			continue
+		}
+		persisted = rev
+	}
+}
+
+// start sends the snapshot and everything relayed after it
+func (c *draftClient) start(init any, rev int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ready = true
+	c.since = rev
+	c.write(init)
+	for _, e := range c.backlog {
+		c.forward(e)
+	}
+	c.backlog = nil
+}
+
+func (c *draftClient) deliver(e draftEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.ready {
+		c.backlog = append(c.backlog, e)
+		return
+	}
+	c.forward(e)
+}
+
+// forward turns the own ops of the client into acks and skips its own presence
+func (c *draftClient) forward(e draftEntry) {
+	if e.Type == "op" {
+		if e.Rev <= c.since {
+			return
+		}
+		c.since = e.Rev
+		if e.ClientID == c.id {
+			c.write(draftEntry{Type: "ack", Rev: e.Rev})
+			return
+		}
+	} else if e.ClientID == c.id {
+		return
+	}
+	c.write(e)
+}
+
+// write queues a message, a client too slow to keep up is disconnected and has to reload
+func (c *draftClient) write(v any) {
+	if c.closed {
+		return
+	}
+	msg, err := json.Marshal(v)
+	if err != nil {
+		di.Zap().Errorf("failed to encode draft message: %s", err)
+		return
+	}
+	select {
+	case c.send <- msg:
+	default:
+		c.closed = true
+		c.cancel()
+	}
+}
+
+// revoke tells the client it lost access to the draft and disconnects it
+func (c *draftClient) revoke() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.write(draftEntry{Type: "error", Data: map[string]any{"message": "request no permission", "reload": false}})
+	c.closed = true
+	c.cancel()
+}
+
+// touchDraftPresence stores the presence of c, nil keeps what it announced last
+func touchDraftPresence(ctx context.Context, tid uint, c *draftClient, presence json.RawMessage) {
+	key := draftPresenceKey(tid)
+	if presence == nil {
+		if raw, err := di.GoRedis().HGet(ctx, key, c.id).Bytes(); err == nil {
+			var p draftPresence
+			if json.Unmarshal(raw, &p) == nil {
+				presence = p.Presence
+			}
+		}
+	}
+	p, _ := json.Marshal(draftPresence{UserID: c.userID, ClientID: c.id, Presence: presence, SeenAt: time.Now()})
+	di.GoRedis().HSet(ctx, key, c.id, p)
+	di.GoRedis().Expire(ctx, key, draftTTL)
+}
+
+type draftPresence struct {
+	UserID   uint            `json:"userID"`
+	ClientID string          `json:"clientID"`
+	Presence json.RawMessage `json:"presence,omitempty"`
+	SeenAt   time.Time       `json:"seenAt"`
+}
+
+// draftPresences lists who is in the draft, entries left behind by lost instances are dropped
+func draftPresences(ctx context.Context, tid uint) ([]draftPresence, error) {
+	all, err := di.GoRedis().HGetAll(ctx, draftPresenceKey(tid)).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]draftPresence, 0, len(all))
+	for id, raw := range all {
+		var p draftPresence
+		if json.Unmarshal([]byte(raw), &p) != nil || time.Since(p.SeenAt) > draftPresenceStale {
+			di.GoRedis().HDel(ctx, draftPresenceKey(tid), id)
+			continue
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
@@ -0,0 +1,304 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"hammer-web-api/di"
+	"hammer-web-api/models"
+	"strconv"
+	"time"
+	"unicode/utf16"
+)
+
+// a draft lives in redis while it is edited so every instance works on the same history:
+// the state hash holds the content at revision base, the ops list every later operation
+const (
+	draftTTL        = 24 * time.Hour
+	draftKeepOps    = 100
+	draftCompactAt  = 300
+	draftOpRetries  = 10
+	draftServerPeer = "server"
+)
+
+var (
+	errDraftRevision = errors.New("revision is no longer available, please reload the draft")
+	errDraftBusy     = errors.New("draft is too busy, please retry")
+)
+
+type draftState struct {
+	Rev           int    `json:"rev"`
+	Content       string `json:"content"`
+	BaseVersionID uint   `json:"baseVersionID"`
+}
+
+// draftEntry is an operation in the history and the message announcing it
+type draftEntry struct {
+	Type     string `json:"type"`
+	Rev      int    `json:"rev,omitempty"`
+	Op       textOp `json:"op,omitempty"`
+	UserID   uint   `json:"userID,omitempty"`
+	ClientID string `json:"clientID,omitempty"`
+
+	Cursor   *int            `json:"cursor,omitempty"`
+	Presence json.RawMessage `json:"presence,omitempty"`
+	Data     any             `json:"data,omitempty"`
+}
+
+func draftStateKey(tid uint) string    { return fmt.Sprintf("draft:%d:state", tid) }
+func draftOpsKey(tid uint) string      { return fmt.Sprintf("draft:%d:ops", tid) }
+func draftPresenceKey(tid uint) string { return fmt.Sprintf("draft:%d:presence", tid) }
+func draftChannel(tid uint) string     { return fmt.Sprintf("draft:%d", tid) }
+
+// ensureDraft seeds redis from the persisted draft, or the latest version, when no session holds it
+func ensureDraft(ctx context.Context, tid uint) error {
+	rdb := di.GoRedis()
+	n, err := rdb.Exists(ctx, draftStateKey(tid)).Result()
+	if err != nil || n > 0 {
+		return err
+	}
+
+	var draft models.TextbookDraft
+	res := di.Gorm().Where("textbook_id = ?", tid).Limit(1).Find(&draft)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		var latest models.TextbookVersion
+		if err = di.Gorm().Select("id", "blob_hash", "content").Where("textbook_id = ?", tid).
+			Order("created_at DESC").First(&latest).Error; err != nil {
+			return err
+		}
+		if err = loadVersionContent(di.Gorm(), &latest); err != nil {
+			return err
+		}
+		draft = models.TextbookDraft{Content: latest.Content, TextbookID: tid, BaseVersionID: latest.ID}
+	}
+
+	// the ops list is dropped as well in case it outlived its state
+	err = rdb.Watch(ctx, func(tx *redis.Tx) error {
+		n, err := tx.Exists(ctx, draftStateKey(tid)).Result()
+		if err != nil || n > 0 {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.HSet(ctx, draftStateKey(tid), "base", draft.Revision, "content", draft.Content, "version", draft.BaseVersionID)
+			p.Del(ctx, draftOpsKey(tid))
+			p.Expire(ctx, draftStateKey(tid), draftTTL)
+			return nil
+		})
+		return err
+	}, draftStateKey(tid))
+	if errors.Is(err, redis.TxFailedErr) {
+		// another instance seeded it first
+		return nil
+	}
+	return err
+}
+
+// loadDraftState returns the draft at its latest revision
+func loadDraftState(ctx context.Context, tid uint) (*draftState, error) {
+	if err := ensureDraft(ctx, tid); err != nil {
+		return nil, err
+	}
+	var state *draftState
+	err := di.GoRedis().Watch(ctx, func(tx *redis.Tx) error {
+		var err error
+		state, err = readDraftState(ctx, tx, tid)
+		return err
+	}, draftStateKey(tid), draftOpsKey(tid))
+	return state, err
+}
+
+func readDraftState(ctx context.Context, tx *redis.Tx, tid uint) (*draftState, error) {
+	fields, err := tx.HGetAll(ctx, draftStateKey(tid)).Result()
+	if err != nil {
+		return nil, err
+	}
+	base, _ := strconv.Atoi(fields["base"])
+	version, _ := strconv.ParseUint(fields["version"], 10, 0)
+	raw, err := tx.LRange(ctx, draftOpsKey(tid), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	state := &draftState{Rev: base, Content: fields["content"], BaseVersionID: uint(version)}
+	for _, r := range raw {
+		var e draftEntry
+		if err = json.Unmarshal([]byte(r), &e); err != nil {
+			return nil, err
+		}
+		if state.Content, err = e.Op.apply(state.Content); err != nil {
+			return nil, err
+		}
+		state.Rev++
+	}
+	return state, nil
+}
+
+// submitDraftOp transforms op, made against revision rev, over everything applied since,
+// appends it to the history and announces it, the new revision is returned
+func submitDraftOp(ctx context.Context, tid uint, rev int, op textOp, userID uint, clientID string) (int, error) {
+	rdb := di.GoRedis()
+	for i := 0; i < draftOpRetries; i++ {
+		var newRev int
+		err := rdb.Watch(ctx, func(tx *redis.Tx) error {
+			base, err := tx.HGet(ctx, draftStateKey(tid), "base").Int()
+			if err != nil {
+				return err
+			}
+			raw, err := tx.LRange(ctx, draftOpsKey(tid), 0, -1).Result()
+			if err != nil {
+				return err
+			}
+			head := base + len(raw)
+			if rev < base || rev > head {
+				return errDraftRevision
+			}
+			// a retry starts again from the op as the client sent it
+			transformed := op
+			for _, r := range raw[rev-base:] {
+				var e draftEntry
+				if err = json.Unmarshal([]byte(r), &e); err != nil {
+					return err
+				}
+				if transformed, _, err = transformOp(transformed, e.Op); err != nil {
+					return err
+				}
+			}
+			// the op has to fit the head document, not only the concurrent ops
+			state, err := readDraftState(ctx, tx, tid)
+			if err != nil {
+				return err
+			}
+			if _, err = transformed.apply(state.Content); err != nil {
+				return err
+			}
+
+			newRev = head + 1
+			entry, err := json.Marshal(draftEntry{Type: "op", Rev: newRev, Op: transformed, UserID: userID, ClientID: clientID})
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+				p.RPush(ctx, draftOpsKey(tid), entry)
+				p.Expire(ctx, draftStateKey(tid), draftTTL)
+				p.Expire(ctx, draftOpsKey(tid), draftTTL)
+				p.Publish(ctx, draftChannel(tid), entry)
+				return nil
+			})
+			return err
+		}, draftStateKey(tid), draftOpsKey(tid))
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return newRev, err
+	}
+	return 0, errDraftBusy
+}
+
+// draftRevision returns the latest revision without replaying the history
+func draftRevision(ctx context.Context, tid uint) (int, error) {
+	pipe := di.GoRedis().Pipeline()
+	base := pipe.HGet(ctx, draftStateKey(tid), "base")
+	n := pipe.LLen(ctx, draftOpsKey(tid))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	b, err := base.Int()
+	return b + int(n.Val()), err
+}
+
+// publishDraft announces a message to every participant of the draft
+func publishDraft(ctx context.Context, tid uint, entry draftEntry) error {
+	msg, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return di.GoRedis().Publish(ctx, draftChannel(tid), msg).Err()
+}
+
+// persistDraft stores the latest revision in the database and folds old history into the
+// snapshot, revisions older than the kept history have to reload the draft
+func persistDraft(ctx context.Context, tid uint) error {
+	state, err := loadDraftState(ctx, tid)
+	if err != nil {
+		return err
+	}
+	draft := models.TextbookDraft{
+		Content:       state.Content,
+		Revision:      uint(state.Rev),
+		TextbookID:    tid,
+		BaseVersionID: state.BaseVersionID,
+	}
+	err = di.Gorm().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&draft).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.TextbookDraft{}).Where("textbook_id = ? AND revision <= ?", tid, state.Rev).
+			Updates(map[string]any{"content": draft.Content, "revision": draft.Revision, "base_version_id": draft.BaseVersionID}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	rdb := di.GoRedis()
+	err = rdb.Watch(ctx, func(tx *redis.Tx) error {
+		base, err := tx.HGet(ctx, draftStateKey(tid), "base").Int()
+		if err != nil {
+			return err
+		}
+		raw, err := tx.LRange(ctx, draftOpsKey(tid), 0, -1).Result()
+		if err != nil || len(raw) < draftCompactAt {
+			return err
+		}
+		content, err := tx.HGet(ctx, draftStateKey(tid), "content").Result()
+		if err != nil {
+			return err
+		}
+		fold := len(raw) - draftKeepOps
+		for _, r := range raw[:fold] {
+			var e draftEntry
+			if err = json.Unmarshal([]byte(r), &e); err != nil {
+				return err
+			}
+			if content, err = e.Op.apply(content); err != nil {
+				return err
+			}
+		}
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.HSet(ctx, draftStateKey(tid), "base", base+fold, "content", content)
+			p.LTrim(ctx, draftOpsKey(tid), int64(fold), -1)
+			return nil
+		})
+		return err
+	}, draftStateKey(tid), draftOpsKey(tid))
+	if errors.Is(err, redis.TxFailedErr) {
+		// someone edited meanwhile, compaction waits for the next round
+		return nil
+	}
+	return err
+}
+
+// replaceOp is an operation turning old into new by replacing what lies between their
+// common prefix and suffix
+func replaceOp(old, new string) textOp {
+	a, b := utf16.Encode([]rune(old)), utf16.Encode([]rune(new))
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	var ob opBuilder
+	ob.retain(pre)
+	ob.delete(len(a) - pre - suf)
+	ob.insert(string(utf16.Decode(b[pre : len(b)-suf])))
+	ob.retain(suf)
+	return ob.op
+}
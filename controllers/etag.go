@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// versionETag is the strong entity tag of a textbook whose latest version is vid
+func versionETag(vid uint) string {
+	return fmt.Sprintf(`"v%d"`, vid)
+}
+
+// representationETag is the entity tag of a whole textbook response, it starts with the
+// latest version so it can be sent back in If-Match, and the hash of body changes with
+// everything else in the response, like the fork count
+func representationETag(vid uint, body []byte) string {
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf(`"v%d-%s"`, vid, hex.EncodeToString(sum[:8]))
+}
+
+// parseVersionETag returns the version ids of a comma separated If-Match or If-None-Match value
+// made of version or representation tags, wildcard is true for "*"
+func parseVersionETag(header string) (vids []uint, wildcard bool) {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			wildcard = true
+			continue
+		}
+		tag = strings.TrimPrefix(tag, "W/")
+		if len(tag) < 4 || !strings.HasPrefix(tag, `"v`) || !strings.HasSuffix(tag, `"`) {
+			continue
+		}
+		tag, _, _ = strings.Cut(tag[2:len(tag)-1], "-")
+		vid, err := strconv.ParseUint(tag, 10, 0)
+		if err != nil {
+			continue
+		}
+		vids = append(vids, uint(vid))
+	}
+	return
+}
+
+// checkNotModified sets the validators of a representation and answers 304 Not Modified
+// when If-None-Match or, without it, If-Modified-Since shows the client copy is current
+func checkNotModified(c *gin.Context, etag string, lastModified time.Time) bool {
+	c.Header("ETag", etag)
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		return false
+	}
+
+	notModified := false
+	if inm := c.GetHeader("If-None-Match"); inm != "" {
+		// If-None-Match uses the weak comparison
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == strings.TrimPrefix(etag, "W/") {
+				notModified = true
+				break
+			}
+		}
+	} else if ims := c.GetHeader("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil && !lastModified.Truncate(time.Second).After(t) {
+			notModified = true
+		}
+	}
+	if notModified {
+		c.Status(http.StatusNotModified)
+		c.Writer.WriteHeaderNow()
+	}
+	return notModified
+}
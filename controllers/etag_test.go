@@ -0,0 +1,28 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVersionETag(t *testing.T) {
+	etag := representationETag(7, []byte(`{"forkCount":1}`))
+	if etag == representationETag(7, []byte(`{"forkCount":2}`)) {
+		t.Errorf("etag %s does not change with the body", etag)
+	}
+
+	cases := map[string][]uint{
+		`"v7"`:                  {7},
+		etag:                    {7},
+		`W/"v3", "v9-abcdef"`:   {3, 9},
+		`"x7", "v", "vx-1", ""`: nil,
+	}
+	for header, want := range cases {
+		if got, wildcard := parseVersionETag(header); !reflect.DeepEqual(got, want) || wildcard {
+			t.Errorf("parseVersionETag(%s) = %v, %v, want %v", header, got, wildcard, want)
+		}
+	}
+	if _, wildcard := parseVersionETag(`*`); !wildcard {
+		t.Error("* is not a wildcard")
+	}
+}
@@ -0,0 +1,248 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/mix-go/xutil/xenv"
+	"hammer-web-api/di"
+	"hammer-web-api/middleware"
+	"hammer-web-api/models"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	EventNotification         = "notification"
+	EventReadingSync          = "reading-sync"
+	EventCollaboratorActivity = "collaborator-activity"
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: allowedOrigin,
+}
+
+type EventController struct {
+}
+
+// Ticket trades the access token for a stream ticket, the ticket goes in the url of
+// the event and draft streams so the token itself never shows up in a url
+func (t *EventController) Ticket(c *gin.Context) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "failed to get principal"})
+		return
+	}
+	ticket, err := di.IssueStreamTicket(c.Request.Context(), di.StreamTicket{
+		UserID:    principal.UserID,
+		Roles:     principal.Roles,
+		TokenID:   principal.TokenID,
+		Version:   principal.Version,
+		ExpiresAt: principal.ExpiresAt,
+	})
+	if err != nil {
+		di.Zap().Errorf("failed to issue stream ticket of user %d: %s", principal.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data": gin.H{
+			"ticket":    ticket,
+			"expiresIn": int(di.StreamTicketTTL.Seconds()),
+		},
+	})
+}
+
+// Stream pushes the events of the user as server-sent events
+func (t *EventController) Stream(c *gin.Context) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "failed to get principal"})
+		return
+	}
+
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("lastEventID")
+	}
+	if lastID != "" && !di.ValidEventID(lastID) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid last event id"})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	fmt.Fprint(c.Writer, "retry: 3000\n\n")
+	c.Writer.Flush()
+
+	streamEvents(c.Request.Context(), principal, lastID, func(e di.Event) error {
+		_, err := fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data)
+		c.Writer.Flush()
+		return err
+	}, func() error {
+		_, err := fmt.Fprint(c.Writer, ": ping\n\n")
+		c.Writer.Flush()
+		return err
+	})
+}
+
+// WebSocket pushes the same events as Stream as json messages over a websocket
+func (t *EventController) WebSocket(c *gin.Context) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "failed to get principal"})
+		return
+	}
+	lastID := c.Query("lastEventID")
+	if lastID != "" && !di.ValidEventID(lastID) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid last event id"})
+		return
+	}
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		di.Zap().Errorf("failed to upgrade websocket: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	// the read loop only serves control frames, the stream ends once the client goes away
+	heartbeat := eventHeartbeat()
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+	})
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	streamEvents(ctx, principal, lastID, func(e di.Event) error {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteJSON(e)
+	}, func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+	})
+}
+
+// streamEvents replays the events after lastID and then sends live ones until ctx is done,
+// the subscription starts before the replay so nothing published in between is lost.
+// The stream ends with the token of principal, the client reconnects with a new one
+func streamEvents(ctx context.Context, principal *middleware.Principal, lastID string, send func(di.Event) error, ping func() error) {
+	userID := principal.UserID
+	bus := di.Events()
+	sub := bus.Subscribe(userID)
+	defer bus.Unsubscribe(sub)
+
+	if lastID != "" {
+		events, err := bus.Since(ctx, userID, lastID)
+		if err != nil {
+			di.Zap().Errorf("failed to replay events of user %d: %s", userID, err)
+			return
+		}
+		for _, e := range events {
+			if send(e) != nil {
+				return
+			}
+			lastID = e.ID
+		}
+	}
+
+	ticker := time.NewTicker(eventHeartbeat())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-sub.C:
+			// a closed subscription fell behind, the client resumes from lastID
+			if !ok {
+				return
+			}
+			if lastID != "" && !di.EventIDLess(lastID, e.ID) {
+				continue
+			}
+			if send(e) != nil {
+				return
+			}
+			lastID = e.ID
+		case <-ticker.C:
+			if !principalActive(ctx, principal) || ping() != nil {
+				return
+			}
+		}
+	}
+}
+
+// principalActive tells whether a stream of principal may go on, a failed check
+// leaves it open until the next heartbeat
+func principalActive(ctx context.Context, principal *middleware.Principal) bool {
+	active, err := principal.Active(ctx)
+	if err != nil {
+		di.Zap().Errorf("failed to check token of user %d: %s", principal.UserID, err)
+		return true
+	}
+	return active
+}
+
+// allowedOrigin accepts websockets from the same host, from the origins listed in the comma
+// separated ALLOWED_ORIGINS and from clients that send no Origin at all, which are not browsers
+func allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range strings.Split(xenv.Getenv("ALLOWED_ORIGINS").String(), ",") {
+		if allowed = strings.TrimSpace(allowed); allowed != "" && strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func eventHeartbeat() time.Duration {
+	return time.Duration(xenv.Getenv("EVENTS_HEARTBEAT").Int64(25)) * time.Second
+}
+
+// publishEvent pushes an event to the connected clients of userID
+func publishEvent(userID uint, typ string, data any) {
+	if err := di.Events().Publish(context.Background(), userID, typ, data); err != nil {
+		di.Zap().Errorf("failed to publish %s event to user %d: %s", typ, userID, err)
+	}
+}
+
+// publishCollaboratorActivity tells the author and the collaborator of a textbook what the other one did
+func publishCollaboratorActivity(textbook models.Textbook, actorID uint, action string, data gin.H) {
+	data["action"] = action
+	data["textbookID"] = textbook.ID
+	data["actorID"] = actorID
+	recipients := []uint{textbook.AuthorID}
+	if textbook.CollaboratorID != nil {
+		recipients = append(recipients, *textbook.CollaboratorID)
+	}
+	for _, uid := range recipients {
+		if uid != actorID {
+			publishEvent(uid, EventCollaboratorActivity, data)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowedOrigin(t *testing.T) {
+	t.Setenv("ALLOWED_ORIGINS", "https://hammer.wang, https://app.hammer.wang")
+	cases := map[string]bool{
+		"":                         true,
+		"http://api.example.com":   true,
+		"https://hammer.wang":      true,
+		"https://app.hammer.wang":  true,
+		"https://evil.example":     false,
+		"https://hammer.wang.evil": false,
+		"null":                     false,
+	}
+	for origin, want := range cases {
+		r := httptest.NewRequest(http.MethodGet, "http://api.example.com/api/v1/events/ws", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if got := allowedOrigin(r); got != want {
+			t.Errorf("allowedOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+}
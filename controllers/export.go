@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/mix-go/xcli"
+	"github.com/mix-go/xutil/xenv"
+	"hammer-web-api/di"
+	"hammer-web-api/models"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+var errNoExportKey = errors.New("EXPORT_SIGNING_KEY is not set")
+
+// PostExport queues an export of all the data of the current user
+func (t *UserController) PostExport(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	if c.Param("id") != strconv.FormatUint(uint64(userID), 10) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return
+	}
+	// an export nobody can download is not worth building
+	if _, err := exportSigningKey(); err != nil {
+		di.Zap().Errorf("failed to queue export of user %d: %s", userID, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": "data export is not available"})
+		return
+	}
+
+	var running int64
+	res := di.Gorm().Model(&models.DataExport{}).
+		Where("user_id = ? AND status IN ?", userID, []string{models.ExportPending, models.ExportRunning}).Count(&running)
+	if res.Error != nil {
+		di.Zap().Errorf("failed to count exports of user %d: %s", userID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	if running > 0 {
+		c.JSON(http.StatusConflict, gin.H{"message": "an export is already in progress"})
+		return
+	}
+
+	export := models.DataExport{Status: models.ExportPending, UserID: userID}
+	if res = di.Gorm().Create(&export); res.Error != nil {
+		di.Zap().Errorf("failed to create export of user %d: %s", userID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	wakeExportWorker()
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "OK",
+		"data":    exportView(export),
+	})
+}
+
+func (t *UserController) GetExports(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	if c.Param("id") != strconv.FormatUint(uint64(userID), 10) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return
+	}
+
+	var exports []models.DataExport
+	if res := di.Gorm().Where("user_id = ?", userID).Order("id DESC").Limit(20).Find(&exports); res.Error != nil {
+		di.Zap().Errorf("failed to query exports of user %d: %s", userID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	data := make([]gin.H, 0, len(exports))
+	for _, export := range exports {
+		data = append(data, exportView(export))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    data,
+	})
+}
+
+// DownloadExport serves a ready export to whoever holds a valid signed link,
+// so it needs no token and can be opened straight from a notification
+func (t *UserController) DownloadExport(c *gin.Context) {
+	uid, err1 := strconv.ParseUint(c.Param("id"), 10, 0)
+	eid, err2 := strconv.ParseUint(c.Param("eid"), 10, 0)
+	expires, err3 := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid download link"})
+		return
+	}
+	if time.Now().Unix() > expires {
+		c.JSON(http.StatusGone, gin.H{"message": "download link has expired"})
+		return
+	}
+	want, err := signExport(uint(uid), uint(eid), expires)
+	if err != nil {
+		di.Zap().Errorf("failed to check download link of export %d: %s", eid, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": "data export is not available"})
+		return
+	}
+	if !hmac.Equal([]byte(c.Query("signature")), []byte(want)) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "invalid download link"})
+		return
+	}
+
+	var export models.DataExport
+	res := di.Gorm().Where("id = ? AND user_id = ? AND status = ?", eid, uid, models.ExportReady).First(&export)
+	if res.Error != nil || export.ExpiresAt == nil || export.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "export not found"})
+		return
+	}
+	path := filepath.Join(ExportDir(), export.FileName)
+	if _, err := os.Stat(path); err != nil {
+		di.Zap().Errorf("failed to stat export %d: %s", export.ID, err)
+		c.JSON(http.StatusNotFound, gin.H{"message": "export not found"})
+		return
+	}
+
+	c.Header("Cache-Control", "private, no-store")
+	c.FileAttachment(path, fmt.Sprintf("hammer-export-%d-%s.zip", uid, export.CompletedAt.Format("20060102")))
+}
+
+// exportView adds a fresh download link to ready exports
+func exportView(export models.DataExport) gin.H {
+	view := gin.H{
+		"id":          export.ID,
+		"status":      export.Status,
+		"size":        export.Size,
+		"createdAt":   export.CreatedAt,
+		"completedAt": export.CompletedAt,
+		"expiresAt":   export.ExpiresAt,
+	}
+	if export.Status == models.ExportReady && export.ExpiresAt != nil && export.ExpiresAt.After(time.Now()) {
+		if url, err := exportDownloadURL(export); err != nil {
+			di.Zap().Errorf("failed to sign download link of export %d: %s", export.ID, err)
+		} else {
+			view["downloadURL"] = url
+		}
+	}
+	return view
+}
+
+// exportDownloadURL signs a link valid for EXPORT_LINK_TTL minutes, never past the export itself
+func exportDownloadURL(export models.DataExport) (string, error) {
+	expires := time.Now().Add(time.Duration(xenv.Getenv("EXPORT_LINK_TTL").Int64(60)) * time.Minute)
+	if export.ExpiresAt != nil && export.ExpiresAt.Before(expires) {
+		expires = *export.ExpiresAt
+	}
+	signature, err := signExport(export.UserID, export.ID, expires.Unix())
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/api/v1/users/%d/exports/%d/download?expires=%d&signature=%s",
+		appURL(), export.UserID, export.ID, expires.Unix(), signature), nil
+}
+
+func signExport(uid, eid uint, expires int64) (string, error) {
+	key, err := exportSigningKey()
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%d:%d:%d", uid, eid, expires)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// exportSigningKey is only used for download links, with an empty key anyone could sign one
+func exportSigningKey() ([]byte, error) {
+	key := xenv.Getenv("EXPORT_SIGNING_KEY").String()
+	if key == "" {
+		return nil, errNoExportKey
+	}
+	return []byte(key), nil
+}
+
+// ExportDir keeps exports out of the public upload dir, they are only served through signed links
+func ExportDir() string {
+	return xenv.Getenv("EXPORT_DIR").String(fmt.Sprintf("%s/../runtime/exports", xcli.App().BasePath))
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExportFileName(t *testing.T) {
+	cases := map[string]string{
+		"Go 入门/基础":       "Go_入门_基础",
+		"1.0.0":          "1.0.0",
+		"../../etc/pass": ".._.._etc_pass",
+		"":               "untitled",
+		"///":            "untitled",
+	}
+	for in, want := range cases {
+		if got := exportFileName(in); got != want {
+			t.Errorf("exportFileName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSignExport(t *testing.T) {
+	t.Setenv("EXPORT_SIGNING_KEY", "secret")
+	sign := func(uid, eid uint, expires int64) string {
+		sig, err := signExport(uid, eid, expires)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+	sig := sign(1, 2, 1700000000)
+	if sig != sign(1, 2, 1700000000) {
+		t.Fatal("signature is not stable")
+	}
+	for _, other := range []string{sign(1, 3, 1700000000), sign(2, 2, 1700000000), sign(1, 2, 1700000001)} {
+		if other == sig {
+			t.Fatal("signature does not cover uid, eid and expiry")
+		}
+	}
+}
+
+func TestDownloadExportWithoutKey(t *testing.T) {
+	t.Setenv("EXPORT_SIGNING_KEY", "")
+	t.Setenv("HMAC_SECRET", "")
+	if _, err := signExport(1, 2, 1700000000); err == nil {
+		t.Fatal("signed a link with an empty key")
+	}
+
+	// a signature made with the empty key must not open the export
+	expires := time.Now().Add(time.Hour).Unix()
+	mac := hmac.New(sha256.New, nil)
+	fmt.Fprintf(mac, "%d:%d:%d", 1, 2, expires)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: "1"}, {Key: "eid", Value: "2"}}
+	c.Request = httptest.NewRequest(http.MethodGet,
+		fmt.Sprintf("/?expires=%d&signature=%s", expires, hex.EncodeToString(mac.Sum(nil))), nil)
+	(&UserController{}).DownloadExport(c)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("download without signing key answered %d", w.Code)
+	}
+}
+
+func TestWriteExportFile(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if err := writeExportJSON(zw, "a.json", map[string]int{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(f)
+	if zr.File[0].Name != "a.json" || string(data) != "{\n  \"n\": 1\n}" {
+		t.Fatalf("unexpected entry %s: %q", zr.File[0].Name, data)
+	}
+}
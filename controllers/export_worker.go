@@ -0,0 +1,392 @@
+package controllers
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/mix-go/xutil/xenv"
+	"gorm.io/gorm"
+	"hammer-web-api/di"
+	"hammer-web-api/models"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const exportLease = 10 * time.Minute
+
+var exportWake = make(chan struct{}, 1)
+
+var unsafeFileChars = regexp.MustCompile(`[^\p{L}\p{N}._-]+`)
+
+// wakeExportWorker makes the worker look for queued exports without waiting for the next poll
+func wakeExportWorker() {
+	select {
+	case exportWake <- struct{}{}:
+	default:
+	}
+}
+
+// RunExportWorker builds queued exports and removes expired ones until ctx is done,
+// an export whose builder died is picked up again once its lease runs out
+func RunExportWorker(ctx context.Context) {
+	interval := time.Duration(xenv.Getenv("EXPORT_POLL_INTERVAL").Int64(10)) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		expireExports()
+		for ctx.Err() == nil && processNextExport() {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-exportWake:
+		}
+	}
+}
+
+// processNextExport claims and builds one export, it reports whether there was one
+func processNextExport() bool {
+	var export models.DataExport
+	now := time.Now()
+	res := di.Gorm().Where("status = ? OR (status = ? AND lease_until < ?)", models.ExportPending, models.ExportRunning, now).
+		Order("id").Limit(1).Find(&export)
+	if res.Error != nil || res.RowsAffected == 0 {
+		if res.Error != nil {
+			di.Zap().Errorf("failed to query queued exports: %s", res.Error)
+		}
+		return false
+	}
+	// whole seconds survive the column precision, renewals match the lease exactly
+	lease := now.Add(exportLease).Truncate(time.Second)
+	res = di.Gorm().Model(&models.DataExport{}).
+		Where("id = ? AND status = ? AND (lease_until IS NULL OR lease_until = ?)", export.ID, export.Status, export.LeaseUntil).
+		Updates(map[string]any{"status": models.ExportRunning, "lease_until": lease})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return res.Error == nil
+	}
+
+	name := fmt.Sprintf("%d-%s.zip", export.ID, randomHex(8))
+	release := holdExportLease(export.ID, lease)
+	size, err := buildExport(export.UserID, filepath.Join(ExportDir(), name))
+	lease, held := release()
+	if !held {
+		// another instance took the export over, its result is the one kept
+		di.Zap().Errorf("lost the lease of export %d", export.ID)
+		os.Remove(filepath.Join(ExportDir(), name))
+		return true
+	}
+	export.LeaseUntil = &lease
+	if err != nil {
+		di.Zap().Errorf("failed to build export %d: %s", export.ID, err)
+		di.Gorm().Model(&export).Updates(map[string]any{"status": models.ExportFailed, "error": truncate(err.Error(), 255)})
+		return true
+	}
+	completed := time.Now()
+	expires := completed.Add(time.Duration(xenv.Getenv("EXPORT_TTL_HOURS").Int64(72)) * time.Hour)
+	res = di.Gorm().Model(&export).Where("lease_until = ?", export.LeaseUntil).Updates(map[string]any{
+		"status":       models.ExportReady,
+		"file_name":    name,
+		"size":         size,
+		"completed_at": completed,
+		"expires_at":   expires,
+	})
+	if res.Error != nil || res.RowsAffected == 0 {
+		if res.Error != nil {
+			di.Zap().Errorf("failed to update export %d: %s", export.ID, res.Error)
+		}
+		os.Remove(filepath.Join(ExportDir(), name))
+		return true
+	}
+	notifyExportReady(export.UserID, export.ID)
+	return true
+}
+
+// holdExportLease renews the lease of a running export until release is called, release
+// returns the last lease and whether the export was still held by this instance
+func holdExportLease(id uint, lease time.Time) (release func() (time.Time, bool)) {
+	stop, done := make(chan struct{}), make(chan struct{})
+	held := true
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(exportLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			next := time.Now().Add(exportLease).Truncate(time.Second)
+			res := di.Gorm().Model(&models.DataExport{}).
+				Where("id = ? AND status = ? AND lease_until = ?", id, models.ExportRunning, lease).
+				Update("lease_until", next)
+			if res.Error != nil {
+				di.Zap().Errorf("failed to renew lease of export %d: %s", id, res.Error)
+				continue
+			}
+			if res.RowsAffected == 0 {
+				held = false
+				return
+			}
+			lease = next
+		}
+	}()
+	return func() (time.Time, bool) {
+		close(stop)
+		<-done
+		return lease, held
+	}
+}
+
+// expireExports deletes the files of exports past their expiry
+func expireExports() {
+	var expired []models.DataExport
+	res := di.Gorm().Select("id", "file_name").Where("status = ? AND expires_at < ?", models.ExportReady, time.Now()).Find(&expired)
+	if res.Error != nil {
+		di.Zap().Errorf("failed to query expired exports: %s", res.Error)
+		return
+	}
+	for _, export := range expired {
+		if err := os.Remove(filepath.Join(ExportDir(), export.FileName)); err != nil && !os.IsNotExist(err) {
+			di.Zap().Errorf("failed to remove export %d: %s", export.ID, err)
+			continue
+		}
+		di.Gorm().Model(&export).Update("status", models.ExportExpired)
+	}
+}
+
+func notifyExportReady(userID, exportID uint) {
+	notifyAsync(func(db *gorm.DB) ([]models.Notification, error) {
+		return []models.Notification{{
+			Kind:     models.NotifyExportReady,
+			Message:  "your data export is ready to download",
+			UserID:   userID,
+			ExportID: &exportID,
+		}}, nil
+	})
+}
+
+// buildExport writes the zip of everything userID owns to path through a temporary file
+func buildExport(userID uint, path string) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return 0, err
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	zw := zip.NewWriter(f)
+	err = writeExport(zw, di.Gorm(), userID)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func writeExport(zw *zip.Writer, db *gorm.DB, userID uint) error {
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return err
+	}
+	err := writeExportJSON(zw, "profile.json", gin.H{
+		"id":        user.ID,
+		"username":  user.Username,
+		"phone":     user.Phone,
+		"email":     user.Email,
+		"birthDay":  user.BirthDay,
+		"profile":   user.Profile,
+		"avatar":    user.Avatar,
+		"createdAt": user.CreatedAt,
+		"updatedAt": user.UpdatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	// textbooks with the content of every version as markdown
+	var textbooks []models.Textbook
+	if err = db.Where("author_id = ?", userID).Order("id").Find(&textbooks).Error; err != nil {
+		return err
+	}
+	for _, textbook := range textbooks {
+		var versions []models.TextbookVersion
+		err = db.Select("id", "no", "content", "blob_hash", "created_at").
+			Where("textbook_id = ?", textbook.ID).Order("created_at").Find(&versions).Error
+		if err != nil {
+			return err
+		}
+		dir := fmt.Sprintf("textbooks/%d-%s", textbook.ID, exportFileName(textbook.Title))
+		list := make([]gin.H, 0, len(versions))
+		for i := range versions {
+			v := &versions[i]
+			if err = loadVersionContent(db, v); err != nil {
+				return err
+			}
+			file := fmt.Sprintf("versions/%d-%s.md", v.ID, exportFileName(v.No))
+			if err = writeExportFile(zw, dir+"/"+file, []byte(v.Content)); err != nil {
+				return err
+			}
+			list = append(list, gin.H{"id": v.ID, "no": v.No, "createdAt": v.CreatedAt, "file": file})
+		}
+		err = writeExportJSON(zw, dir+"/textbook.json", gin.H{
+			"id":             textbook.ID,
+			"title":          textbook.Title,
+			"tag":            textbook.Tag,
+			"desc":           textbook.Desc,
+			"isPrivate":      textbook.IsPrivate,
+			"collaboratorID": textbook.CollaboratorID,
+			"forkedFromID":   textbook.ForkedFromID,
+			"createdAt":      textbook.CreatedAt,
+			"versions":       list,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// operation is a bit set of subscribed 1, watch later 2 and rated 4
+	var operations []models.UserOperation
+	if err = db.Where("user_id = ?", userID).Order("id").Find(&operations).Error; err != nil {
+		return err
+	}
+	subscriptions := make([]gin.H, 0, len(operations))
+	for _, op := range operations {
+		subscriptions = append(subscriptions, gin.H{
+			"textbookID": op.TextbookID,
+			"subscribed": op.Operation&1 != 0,
+			"watchLater": op.Operation&2 != 0,
+			"rated":      op.Operation&4 != 0,
+			"createdAt":  op.CreatedAt,
+		})
+	}
+	if err = writeExportJSON(zw, "subscriptions.json", subscriptions); err != nil {
+		return err
+	}
+
+	var proposals []models.Proposal
+	if err = db.Where("proposer_id = ?", userID).Order("id").Find(&proposals).Error; err != nil {
+		return err
+	}
+	if err = writeExportJSON(zw, "proposals.json", proposals); err != nil {
+		return err
+	}
+	var comments []models.ProposalComment
+	if err = db.Where("author_id = ?", userID).Order("id").Find(&comments).Error; err != nil {
+		return err
+	}
+	if err = writeExportJSON(zw, "comments.json", comments); err != nil {
+		return err
+	}
+	var progress []models.ReadingProgress
+	if err = db.Where("user_id = ?", userID).Order("id").Find(&progress).Error; err != nil {
+		return err
+	}
+	if err = writeExportJSON(zw, "reading_progress.json", progress); err != nil {
+		return err
+	}
+	var attempts []models.QuizAttempt
+	if err = db.Preload("Answers").Where("user_id = ?", userID).Order("id").Find(&attempts).Error; err != nil {
+		return err
+	}
+	if err = writeExportJSON(zw, "quiz_attempts.json", attempts); err != nil {
+		return err
+	}
+	var paths []models.LearningPath
+	if err = db.Preload("Steps").Where("owner_id = ?", userID).Order("id").Find(&paths).Error; err != nil {
+		return err
+	}
+	if err = writeExportJSON(zw, "learning_paths.json", paths); err != nil {
+		return err
+	}
+	var following, followers []uint
+	if err = db.Model(&models.Follow{}).Where("follower_id = ?", userID).Pluck("followee_id", &following).Error; err != nil {
+		return err
+	}
+	if err = db.Model(&models.Follow{}).Where("followee_id = ?", userID).Pluck("follower_id", &followers).Error; err != nil {
+		return err
+	}
+	if err = writeExportJSON(zw, "follows.json", gin.H{"following": following, "followers": followers}); err != nil {
+		return err
+	}
+	var notifications []models.Notification
+	if err = db.Where("user_id = ?", userID).Order("id").Find(&notifications).Error; err != nil {
+		return err
+	}
+	if err = writeExportJSON(zw, "notifications.json", notifications); err != nil {
+		return err
+	}
+
+	return writeExportFile(zw, "README.md", []byte(exportReadme(user, len(textbooks))))
+}
+
+func exportReadme(user models.User, textbooks int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Data export of %s\n\n", user.Username)
+	fmt.Fprintf(&b, "Created at %s.\n\n", time.Now().Format(time.RFC3339))
+	b.WriteString("- `profile.json`: your account details\n")
+	fmt.Fprintf(&b, "- `textbooks/`: your %d textbooks, every version is a markdown file under `versions/`\n", textbooks)
+	b.WriteString("- `subscriptions.json`: textbooks you subscribed to, saved for later or rated\n")
+	b.WriteString("- `proposals.json` and `comments.json`: your change proposals and review comments\n")
+	b.WriteString("- `reading_progress.json`, `quiz_attempts.json`: your reading progress and quiz results\n")
+	b.WriteString("- `learning_paths.json`: your learning paths with the notes of every step\n")
+	b.WriteString("- `follows.json`, `notifications.json`: who you follow, who follows you and your notifications\n")
+	return b.String()
+}
+
+func writeExportJSON(zw *zip.Writer, name string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeExportFile(zw, name, data)
+}
+
+func writeExportFile(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// exportFileName keeps names portable across file systems
+func exportFileName(s string) string {
+	s = strings.Trim(unsafeFileChars.ReplaceAllString(s, "_"), "_")
+	if r := []rune(s); len(r) > 60 {
+		s = string(r[:60])
+	}
+	if s == "" {
+		s = "untitled"
+	}
+	return s
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
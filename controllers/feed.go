@@ -0,0 +1,329 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/mix-go/xutil/xenv"
+	"github.com/redis/go-redis/v9"
+	"hammer-web-api/di"
+	"hammer-web-api/models"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// activities of authors with fewer followers than the fan-out threshold are pushed into
+// the redis inbox of every follower when they happen, those of popular authors are
+// pulled from the database when the feed is read
+const (
+	feedInboxLen   = 500
+	feedBackfill   = 20
+	feedFanoutPage = 1000
+	feedPopularKey = "feed:popular"
+)
+
+type FeedController struct {
+}
+
+func (t *FeedController) Get(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "20"))
+	if size < 1 || size > 100 {
+		size = 20
+	}
+	before, _ := strconv.ParseUint(c.Query("before"), 10, 0)
+
+	activities, err := readFeed(c.Request.Context(), userID, uint(before), size)
+	if err != nil {
+		di.Zap().Errorf("failed to read feed of user %d: %s", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	var next *uint
+	if len(activities) == size {
+		next = &activities[len(activities)-1].ID
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    activities,
+		"next":    next,
+	})
+}
+
+// readFeed merges the pushed inbox with the activities of followed popular authors,
+// newest first and older than before when it is set
+func readFeed(ctx context.Context, userID, before uint, size int) ([]models.Activity, error) {
+	var followees []uint
+	if err := di.Gorm().Model(&models.Follow{}).Where("follower_id = ?", userID).Pluck("followee_id", &followees).Error; err != nil {
+		return nil, err
+	}
+	if len(followees) == 0 {
+		return []models.Activity{}, nil
+	}
+	following := make(map[uint]bool, len(followees))
+	for _, id := range followees {
+		following[id] = true
+	}
+
+	rdb := di.GoRedis()
+	raw, err := rdb.LRange(ctx, feedInboxKey(userID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	popularRaw, err := rdb.SMembers(ctx, feedPopularKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[uint]bool)
+	var activities []models.Activity
+	oldest := uint(0)
+	for _, r := range raw {
+		var a models.Activity
+		if json.Unmarshal([]byte(r), &a) != nil {
+			continue
+		}
+		if oldest == 0 || a.ID < oldest {
+			oldest = a.ID
+		}
+		if !following[a.ActorID] || (before != 0 && a.ID >= before) || seen[a.ID] {
+			continue
+		}
+		seen[a.ID] = true
+		activities = append(activities, a)
+	}
+
+	var pulled, pushed []uint
+	for _, id := range followees {
+		if containsString(popularRaw, strconv.FormatUint(uint64(id), 10)) {
+			pulled = append(pulled, id)
+		} else {
+			pushed = append(pushed, id)
+		}
+	}
+	// a full inbox has lost its oldest entries, those pages come from the database
+	if len(raw) >= feedInboxLen && len(pushed) > 0 {
+		limit := before
+		if limit == 0 || oldest < limit {
+			limit = oldest
+		}
+		extra, err := queryActivities(pushed, limit, size)
+		if err != nil {
+			return nil, err
+		}
+		activities = appendUnseen(activities, extra, seen)
+	}
+	if len(pulled) > 0 {
+		extra, err := queryActivities(pulled, before, size)
+		if err != nil {
+			return nil, err
+		}
+		activities = appendUnseen(activities, extra, seen)
+	}
+
+	// a textbook or path may have gone private or been deleted since its activities were pushed
+	if activities, err = visibleActivities(activities); err != nil {
+		return nil, err
+	}
+	sort.Slice(activities, func(i, j int) bool { return activities[i].ID > activities[j].ID })
+	if len(activities) > size {
+		activities = activities[:size]
+	}
+	return activities, nil
+}
+
+// visibleActivities drops the activities whose textbook or learning path is no longer public
+func visibleActivities(activities []models.Activity) ([]models.Activity, error) {
+	var textbookIDs, pathIDs []uint
+	for _, a := range activities {
+		if a.TextbookID != nil {
+			textbookIDs = append(textbookIDs, *a.TextbookID)
+		}
+		if a.PathID != nil {
+			pathIDs = append(pathIDs, *a.PathID)
+		}
+	}
+	var public, publicPaths []uint
+	if len(textbookIDs) > 0 {
+		err := di.Gorm().Model(&models.Textbook{}).Where("id IN ? AND is_private = ?", textbookIDs, false).Pluck("id", &public).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(pathIDs) > 0 {
+		err := di.Gorm().Model(&models.LearningPath{}).Where("id IN ? AND is_private = ?", pathIDs, false).Pluck("id", &publicPaths).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return filterActivities(activities, public, publicPaths), nil
+}
+
+func filterActivities(activities []models.Activity, textbookIDs, pathIDs []uint) []models.Activity {
+	visible := make(map[uint]bool, len(textbookIDs))
+	for _, id := range textbookIDs {
+		visible[id] = true
+	}
+	visiblePaths := make(map[uint]bool, len(pathIDs))
+	for _, id := range pathIDs {
+		visiblePaths[id] = true
+	}
+	out := activities[:0]
+	for _, a := range activities {
+		if (a.TextbookID != nil && !visible[*a.TextbookID]) || (a.PathID != nil && !visiblePaths[*a.PathID]) {
+			continue
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+func queryActivities(actorIDs []uint, before uint, size int) ([]models.Activity, error) {
+	var activities []models.Activity
+	query := di.Gorm().Where("actor_id IN ?", actorIDs)
+	if before != 0 {
+		query = query.Where("id < ?", before)
+	}
+	err := query.Order("id DESC").Limit(size).Find(&activities).Error
+	return activities, err
+}
+
+func appendUnseen(activities, extra []models.Activity, seen map[uint]bool) []models.Activity {
+	for _, a := range extra {
+		if !seen[a.ID] {
+			seen[a.ID] = true
+			activities = append(activities, a)
+		}
+	}
+	return activities
+}
+
+// recordActivity stores an activity of a public textbook or learning path and spreads it
+// to the followers of its actor in the background
+func recordActivity(a models.Activity) {
+	go func() {
+		if err := fanoutActivity(context.Background(), &a); err != nil {
+			di.Zap().Errorf("failed to record %s activity of user %d: %s", a.Kind, a.ActorID, err)
+		}
+	}()
+}
+
+func fanoutActivity(ctx context.Context, a *models.Activity) error {
+	if err := di.Gorm().Create(a).Error; err != nil {
+		return err
+	}
+	raw, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	rdb := di.GoRedis()
+	var followers int64
+	if err = di.Gorm().Model(&models.Follow{}).Where("followee_id = ?", a.ActorID).Count(&followers).Error; err != nil {
+		return err
+	}
+	actor := strconv.FormatUint(uint64(a.ActorID), 10)
+	if followers >= xenv.Getenv("FEED_FANOUT_THRESHOLD").Int64(1000) {
+		return rdb.SAdd(ctx, feedPopularKey, actor).Err()
+	}
+	if err = rdb.SRem(ctx, feedPopularKey, actor).Err(); err != nil {
+		return err
+	}
+
+	last := uint(0)
+	for {
+		var page []models.Follow
+		err = di.Gorm().Select("id", "follower_id").Where("followee_id = ? AND id > ?", a.ActorID, last).
+			Order("id").Limit(feedFanoutPage).Find(&page).Error
+		if err != nil || len(page) == 0 {
+			return err
+		}
+		_, err = rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+			for _, f := range page {
+				p.LPush(ctx, feedInboxKey(f.FollowerID), raw)
+				p.LTrim(ctx, feedInboxKey(f.FollowerID), 0, feedInboxLen-1)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		last = page[len(page)-1].ID
+	}
+}
+
+// backfillFeed adds the recent activities of a newly followed author to the inbox,
+// the inbox is rewritten in id order so trimming keeps dropping the oldest entries
+func backfillFeed(userID, followeeID uint) {
+	ctx := context.Background()
+	var recent []models.Activity
+	if err := di.Gorm().Where("actor_id = ?", followeeID).Order("id DESC").Limit(feedBackfill).Find(&recent).Error; err != nil || len(recent) == 0 {
+		return
+	}
+	rdb := di.GoRedis()
+	key := feedInboxKey(userID)
+	err := rdb.Watch(ctx, func(tx *redis.Tx) error {
+		raw, err := tx.LRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		seen := make(map[uint]bool)
+		var entries []models.Activity
+		for _, r := range raw {
+			var a models.Activity
+			if json.Unmarshal([]byte(r), &a) == nil && !seen[a.ID] {
+				seen[a.ID] = true
+				entries = append(entries, a)
+			}
+		}
+		entries = appendUnseen(entries, recent, seen)
+		sort.Slice(entries, func(i, j int) bool { return entries[i].ID > entries[j].ID })
+		if len(entries) > feedInboxLen {
+			entries = entries[:feedInboxLen]
+		}
+		values := make([]any, 0, len(entries))
+		for _, a := range entries {
+			b, _ := json.Marshal(a)
+			values = append(values, b)
+		}
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.Del(ctx, key)
+			p.RPush(ctx, key, values...)
+			return nil
+		})
+		return err
+	}, key)
+	if err != nil {
+		di.Zap().Errorf("failed to backfill feed of user %d: %s", userID, err)
+	}
+}
+
+func feedInboxKey(userID uint) string {
+	return fmt.Sprintf("feed:inbox:%d", userID)
+}
+
+// recordTextbookActivity records a new textbook, or a new version when version is set,
+// on behalf of the textbook author, private textbooks stay out of feeds
+func recordTextbookActivity(textbook models.Textbook, version *models.TextbookVersion) {
+	if textbook.IsPrivate {
+		return
+	}
+	a := models.Activity{
+		Kind:       models.ActivityTextbook,
+		Title:      textbook.Title,
+		ActorID:    textbook.AuthorID,
+		TextbookID: &textbook.ID,
+	}
+	if version != nil {
+		a.Kind = models.ActivityVersion
+		a.VersionID = &version.ID
+		a.Version = version.No
+	}
+	recordActivity(a)
+}
@@ -0,0 +1,20 @@
+package controllers
+
+import (
+	"hammer-web-api/models"
+	"testing"
+)
+
+func TestFilterActivities(t *testing.T) {
+	public, private, path := uint(1), uint(2), uint(3)
+	activities := []models.Activity{
+		{ID: 1, TextbookID: &public},
+		{ID: 2, TextbookID: &private},
+		{ID: 3, PathID: &path},
+		{ID: 4, PathID: &private},
+	}
+	got := filterActivities(activities, []uint{public}, []uint{path})
+	if len(got) != 2 || got[0].ID != 1 || got[1].ID != 3 {
+		t.Errorf("expected activities 1 and 3, got %v", got)
+	}
+}
@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+	"hammer-web-api/di"
+	"hammer-web-api/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (t *UserController) PostFollow(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	followeeID, ok := parseFolloweeID(c, userID)
+	if !ok {
+		return
+	}
+
+	follow := models.Follow{FollowerID: userID, FolloweeID: followeeID}
+	res := di.Gorm().Clauses(clause.OnConflict{DoNothing: true}).Create(&follow)
+	if res.Error != nil {
+		di.Zap().Errorf("failed to follow user %d: %s", followeeID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	if res.RowsAffected > 0 {
+		go backfillFeed(userID, followeeID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+	})
+}
+
+func (t *UserController) DeleteFollow(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	followeeID, ok := parseFolloweeID(c, userID)
+	if !ok {
+		return
+	}
+
+	// entries already in the feed are filtered out when it is read
+	res := di.Gorm().Where("follower_id = ? AND followee_id = ?", userID, followeeID).Delete(&models.Follow{})
+	if res.Error != nil {
+		di.Zap().Errorf("failed to unfollow user %d: %s", followeeID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+	})
+}
+
+func (t *UserController) GetFollowers(c *gin.Context) {
+	listFollows(c, "followee_id", "follower_id")
+}
+
+func (t *UserController) GetFollowing(c *gin.Context) {
+	listFollows(c, "follower_id", "followee_id")
+}
+
+func (t *UserController) GetFollowStats(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	uid, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid user id"})
+		return
+	}
+
+	followers, following, isFollowing, err := followStats(uint(uid), userID)
+	if err != nil {
+		di.Zap().Errorf("failed to count follows of user %d: %s", uid, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data": gin.H{
+			"followers":   followers,
+			"following":   following,
+			"isFollowing": isFollowing,
+		},
+	})
+}
+
+// followStats counts the followers and followees of uid and whether viewer follows uid
+func followStats(uid, viewer uint) (followers, following int64, isFollowing bool, err error) {
+	if err = di.Gorm().Model(&models.Follow{}).Where("followee_id = ?", uid).Count(&followers).Error; err != nil {
+		return
+	}
+	if err = di.Gorm().Model(&models.Follow{}).Where("follower_id = ?", uid).Count(&following).Error; err != nil {
+		return
+	}
+	if viewer != 0 && viewer != uid {
+		var n int64
+		err = di.Gorm().Model(&models.Follow{}).Where("follower_id = ? AND followee_id = ?", viewer, uid).Count(&n).Error
+		isFollowing = n > 0
+	}
+	return
+}
+
+// listFollows lists the users on the other side of the follows where column matches the route user
+func listFollows(c *gin.Context, column, other string) {
+	uid, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid user id"})
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 || size > 100 {
+		size = 20
+	}
+
+	query := di.Gorm().Model(&models.Follow{}).Where(column+" = ?", uid)
+	var total int64
+	if res := query.Count(&total); res.Error != nil {
+		di.Zap().Errorf("failed to count follows: %s", res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	var users []struct {
+		ID         uint      `json:"id"`
+		Username   string    `json:"username"`
+		Avatar     string    `json:"avatar"`
+		FollowedAt time.Time `json:"followedAt"`
+	}
+	res := query.Select("users.id, users.username, users.avatar, follows.created_at AS followed_at").
+		Joins(fmt.Sprintf("JOIN users ON users.id = follows.%s AND users.deleted_at IS NULL", other)).
+		Order("follows.id DESC").Offset((page - 1) * size).Limit(size).Scan(&users)
+	if res.Error != nil {
+		di.Zap().Errorf("failed to query follows: %s", res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    users,
+		"total":   total,
+	})
+}
+
+func parseFolloweeID(c *gin.Context, userID uint) (uint, bool) {
+	uid, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid user id"})
+		return 0, false
+	}
+	if uint(uid) == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "cannot follow yourself"})
+		return 0, false
+	}
+	if di.Gorm().Select("id").First(&models.User{}, uid).RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("user %d not found", uid)})
+		return 0, false
+	}
+	return uint(uid), true
+}
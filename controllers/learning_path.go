@@ -0,0 +1,325 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"hammer-web-api/di"
+	"hammer-web-api/models"
+	"net/http"
+	"strconv"
+)
+
+type LearningPathController struct {
+}
+
+type learningPathForm struct {
+	Title     string                 `json:"title" binding:"required,max=100"`
+	Desc      string                 `json:"desc" binding:"max=255"`
+	IsPrivate bool                   `json:"isPrivate"`
+	Steps     []learningPathStepForm `json:"steps" binding:"required,min=1,dive"`
+}
+
+type learningPathStepForm struct {
+	TextbookID uint   `json:"textbookID" binding:"required"`
+	Note       string `json:"note"`
+}
+
+func (t *LearningPathController) Post(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	path := models.LearningPath{OwnerID: userID}
+	if !bindLearningPath(c, &path) {
+		return
+	}
+
+	if res := di.Gorm().Create(&path); res.Error != nil {
+		di.Zap().Errorf("failed to create learning path: %s", res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	if !path.IsPrivate {
+		recordActivity(models.Activity{
+			Kind:    models.ActivityLearningPath,
+			Title:   path.Title,
+			ActorID: userID,
+			PathID:  &path.ID,
+		})
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "OK",
+		"data":    path,
+	})
+}
+
+func (t *LearningPathController) GetList(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 || size > 100 {
+		size = 20
+	}
+
+	var paths []models.LearningPath
+	query := di.Gorm().Model(&models.LearningPath{}).Where("is_private = ?", false)
+	if owner := c.Query("ownerID"); owner != "" {
+		query = query.Where("owner_id = ?", owner)
+	}
+	var total int64
+	if res := query.Count(&total); res.Error != nil {
+		di.Zap().Errorf("failed to count learning paths: %s", res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	res := query.Preload("Steps", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).Order("created_at DESC").Offset((page - 1) * size).Limit(size).Find(&paths)
+	if res.Error != nil {
+		di.Zap().Errorf("failed to query learning paths: %s", res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    paths,
+		"total":   total,
+	})
+}
+
+func (t *LearningPathController) Get(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	path, ok := loadLearningPath(c, userID)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    path,
+	})
+}
+
+func (t *LearningPathController) Put(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	path, ok := loadLearningPath(c, userID)
+	if !ok {
+		return
+	}
+	if path.OwnerID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return
+	}
+	if !bindLearningPath(c, &path) {
+		return
+	}
+
+	// steps are replaced as a whole to keep positions consistent
+	err := di.Gorm().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("path_id = ?", path.ID).Delete(&models.LearningPathStep{}).Error; err != nil {
+			return err
+		}
+		for i := range path.Steps {
+			path.Steps[i].PathID = path.ID
+		}
+		if err := tx.Create(&path.Steps).Error; err != nil {
+			return err
+		}
+		return tx.Model(&path).Select("title", "desc", "is_private").Updates(&path).Error
+	})
+	if err != nil {
+		di.Zap().Errorf("failed to update learning path %d: %s", path.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    path,
+	})
+}
+
+func (t *LearningPathController) Delete(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	path, ok := loadLearningPath(c, userID)
+	if !ok {
+		return
+	}
+	if path.OwnerID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return
+	}
+
+	err := di.Gorm().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("path_id = ?", path.ID).Delete(&models.LearningPathStep{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&path).Error
+	})
+	if err != nil {
+		di.Zap().Errorf("failed to delete learning path %d: %s", path.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+	})
+}
+
+func (t *LearningPathController) GetProgress(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	path, ok := loadLearningPath(c, userID)
+	if !ok {
+		return
+	}
+
+	textbookIDs := make([]uint, 0, len(path.Steps))
+	for _, step := range path.Steps {
+		textbookIDs = append(textbookIDs, step.TextbookID)
+	}
+	progress, err := readingProgress(userID, textbookIDs)
+	if err != nil {
+		di.Zap().Errorf("failed to query reading progress: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	// every step weighs the same, the next step is the first one not completed
+	steps := make([]gin.H, 0, len(path.Steps))
+	total, completed := uint(0), 0
+	var next *uint
+	for _, step := range path.Steps {
+		p := progress[step.TextbookID]
+		done := isCompleted(p)
+		percent := p.Percent
+		if done {
+			percent = 100
+			completed++
+		} else if next == nil {
+			position := step.Position
+			next = &position
+		}
+		total += percent
+		steps = append(steps, gin.H{
+			"position":   step.Position,
+			"textbookID": step.TextbookID,
+			"percent":    percent,
+			"completed":  done,
+		})
+	}
+	percent := uint(0)
+	if len(path.Steps) > 0 {
+		percent = total / uint(len(path.Steps))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data": gin.H{
+			"percent":        percent,
+			"completedSteps": completed,
+			"totalSteps":     len(path.Steps),
+			"nextPosition":   next,
+			"steps":          steps,
+		},
+	})
+}
+
+// bindLearningPath binds the form into path and checks every step refers to a readable textbook
+func bindLearningPath(c *gin.Context, path *models.LearningPath) bool {
+	lf := learningPathForm{}
+	if err := c.ShouldBindJSON(&lf); err != nil {
+		di.Zap().Errorf("failed to bind form: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+		return false
+	}
+
+	textbookIDs := make([]uint, 0, len(lf.Steps))
+	for _, step := range lf.Steps {
+		textbookIDs = append(textbookIDs, step.TextbookID)
+	}
+	var count int64
+	res := di.Gorm().Model(&models.Textbook{}).
+		Where("id IN ? AND (is_private = ? OR author_id = ?)", textbookIDs, false, path.OwnerID).
+		Distinct("id").Count(&count)
+	if res.Error != nil {
+		di.Zap().Errorf("failed to query textbooks: %s", res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return false
+	}
+	if int(count) != len(uniqueUints(textbookIDs)) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "some textbooks do not exist or are private"})
+		return false
+	}
+
+	path.Title = lf.Title
+	path.Desc = lf.Desc
+	path.IsPrivate = lf.IsPrivate
+	path.Steps = make([]models.LearningPathStep, 0, len(lf.Steps))
+	for i, step := range lf.Steps {
+		path.Steps = append(path.Steps, models.LearningPathStep{
+			Position:   uint(i),
+			Note:       step.Note,
+			PathID:     path.ID,
+			TextbookID: step.TextbookID,
+		})
+	}
+	return true
+}
+
+// loadLearningPath loads the path of the route with ordered steps, private paths only for their owner
+func loadLearningPath(c *gin.Context, userID uint) (models.LearningPath, bool) {
+	var path models.LearningPath
+	pid, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid learning path id"})
+		return path, false
+	}
+	res := di.Gorm().Preload("Steps", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).First(&path, pid)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("learning path %d not found", pid)})
+		} else {
+			di.Zap().Errorf("failed to query learning path: %s", res.Error)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		}
+		return path, false
+	}
+	if path.IsPrivate && path.OwnerID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return path, false
+	}
+	return path, true
+}
+
+func uniqueUints(s []uint) []uint {
+	seen := make(map[uint]struct{}, len(s))
+	out := make([]uint, 0, len(s))
+	for _, v := range s {
+		if _, ok := seen[v]; !ok {
+			seen[v] = struct{}{}
+			out = append(out, v)
+		}
+	}
+	return out
+}
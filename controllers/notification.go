@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"hammer-web-api/di"
+	"hammer-web-api/models"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type NotificationController struct {
+}
+
+func (t *NotificationController) GetList(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 || size > 100 {
+		size = 20
+	}
+
+	query := di.Gorm().Model(&models.Notification{}).Where("user_id = ?", userID)
+	if c.Query("unread") == "true" {
+		query = query.Where("read_at IS NULL")
+	}
+	var total int64
+	if res := query.Count(&total); res.Error != nil {
+		di.Zap().Errorf("failed to count notifications: %s", res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	var notifications []models.Notification
+	if res := query.Order("id DESC").Offset((page - 1) * size).Limit(size).Find(&notifications); res.Error != nil {
+		di.Zap().Errorf("failed to query notifications: %s", res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    notifications,
+		"total":   total,
+	})
+}
+
+func (t *NotificationController) GetUnreadCount(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var count int64
+	res := di.Gorm().Model(&models.Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&count)
+	if res.Error != nil {
+		di.Zap().Errorf("failed to count unread notifications: %s", res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    gin.H{"unread": count},
+	})
+}
+
+func (t *NotificationController) PutRead(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	nid, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid notification id"})
+		return
+	}
+
+	var notification models.Notification
+	if di.Gorm().Where("id = ? AND user_id = ?", nid, userID).First(&notification).RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("notification %d not found", nid)})
+		return
+	}
+	if notification.ReadAt == nil {
+		now := time.Now()
+		if res := di.Gorm().Model(&notification).Update("read_at", now); res.Error != nil {
+			di.Zap().Errorf("failed to mark notification %d read: %s", nid, res.Error)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    notification,
+	})
+}
+
+func (t *NotificationController) PutReadAll(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	res := di.Gorm().Model(&models.Notification{}).Where("user_id = ? AND read_at IS NULL", userID).
+		Update("read_at", time.Now())
+	if res.Error != nil {
+		di.Zap().Errorf("failed to mark notifications read: %s", res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    gin.H{"updated": res.RowsAffected},
+	})
+}
+
+// notifications are built and stored off the request path by a single worker, a caller
+// waits up to notifyEnqueueTimeout for room in a full queue before the job is dropped
+var (
+	notifyQueue = make(chan func(db *gorm.DB) ([]models.Notification, error), 256)
+	notifyOnce  sync.Once
+)
+
+const notifyEnqueueTimeout = time.Second
+
+func notifyAsync(job func(db *gorm.DB) ([]models.Notification, error)) {
+	notifyOnce.Do(func() {
+		go func() {
+			for job := range notifyQueue {
+				runNotifyJob(job)
+			}
+		}()
+	})
+	select {
+	case notifyQueue <- job:
+		return
+	default:
+	}
+	timer := time.NewTimer(notifyEnqueueTimeout)
+	defer timer.Stop()
+	select {
+	case notifyQueue <- job:
+	case <-timer.C:
+		di.Zap().Errorf("failed to queue notifications: queue is full, the job was dropped")
+	}
+}
+
+func runNotifyJob(job func(db *gorm.DB) ([]models.Notification, error)) {
+	notifications, err := job(di.Gorm())
+	if err == nil && len(notifications) > 0 {
+		err = di.Gorm().CreateInBatches(notifications, 500).Error
+	}
+	if err != nil {
+		di.Zap().Errorf("failed to create notifications: %s", err)
+		return
+	}
+	for _, n := range notifications {
+		publishEvent(n.UserID, EventNotification, n)
+	}
+}
+
+// notifyNewVersion tells every subscriber of the textbook except the publisher about a new version
+func notifyNewVersion(textbookID, versionID, actorID uint, no string) {
+	notifyAsync(func(db *gorm.DB) ([]models.Notification, error) {
+		var textbook models.Textbook
+		if err := db.Select("id", "title").First(&textbook, textbookID).Error; err != nil {
+			return nil, err
+		}
+		var subscriberIDs []uint
+		err := db.Model(&models.UserOperation{}).
+			Where("textbook_id = ? AND operation IN ? AND user_id <> ?", textbookID, []uint{1, 3, 5, 7}, actorID).
+			Distinct().Pluck("user_id", &subscriberIDs).Error
+		if err != nil {
+			return nil, err
+		}
+		notifications := make([]models.Notification, 0, len(subscriberIDs))
+		for _, uid := range subscriberIDs {
+			notifications = append(notifications, models.Notification{
+				Kind:       models.NotifyNewVersion,
+				Message:    fmt.Sprintf("%s published version %s", textbook.Title, no),
+				UserID:     uid,
+				ActorID:    &actorID,
+				TextbookID: &textbookID,
+				VersionID:  &versionID,
+			})
+		}
+		return notifications, nil
+	})
+}
+
+// notifyCommentReply tells the author of the parent comment about a reply
+func notifyCommentReply(reply models.ProposalComment, textbookID uint) {
+	notifyAsync(func(db *gorm.DB) ([]models.Notification, error) {
+		var parent models.ProposalComment
+		if err := db.Select("id", "author_id").First(&parent, *reply.ParentID).Error; err != nil {
+			return nil, err
+		}
+		if parent.AuthorID == reply.AuthorID {
+			return nil, nil
+		}
+		var proposal models.Proposal
+		if err := db.Select("id", "title").First(&proposal, reply.ProposalID).Error; err != nil {
+			return nil, err
+		}
+		return []models.Notification{{
+			Kind:       models.NotifyCommentReply,
+			Message:    fmt.Sprintf("your comment on %s got a reply", proposal.Title),
+			UserID:     parent.AuthorID,
+			ActorID:    &reply.AuthorID,
+			TextbookID: &textbookID,
+			ProposalID: &reply.ProposalID,
+		}}, nil
+	})
+}
+
+// notifyInvitation tells a user they were made collaborator of a textbook
+func notifyInvitation(textbook models.Textbook, userID uint) {
+	notifyAsync(func(db *gorm.DB) ([]models.Notification, error) {
+		return []models.Notification{{
+			Kind:       models.NotifyInvitation,
+			Message:    fmt.Sprintf("you were invited to collaborate on %s", textbook.Title),
+			UserID:     userID,
+			ActorID:    &textbook.AuthorID,
+			TextbookID: &textbook.ID,
+		}}, nil
+	})
+}
@@ -0,0 +1,268 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf16"
+)
+
+// opMaxLen bounds every component and document length, so sums of them cannot overflow
+const opMaxLen = math.MaxInt32
+
+var errOpBaseLength = errors.New("operation does not fit the document")
+
+// textOp is a plain text operation in the format of ot.js: positive numbers retain,
+// negative numbers delete and strings insert. Lengths count UTF-16 code units
+// like javascript strings so browser clients and the server agree on positions.
+type textOp []opComponent
+
+type opComponent struct {
+	n int // > 0 retain, < 0 delete
+	s string
+}
+
+func (c opComponent) isInsert() bool { return c.s != "" }
+
+func (c opComponent) isRetain() bool { return c.s == "" && c.n > 0 }
+
+func (c opComponent) isDelete() bool { return c.s == "" && c.n < 0 }
+
+func (op textOp) MarshalJSON() ([]byte, error) {
+	out := make([]any, 0, len(op))
+	for _, c := range op {
+		if c.isInsert() {
+			out = append(out, c.s)
+		} else {
+			out = append(out, c.n)
+		}
+	}
+	return json.Marshal(out)
+}
+
+func (op *textOp) UnmarshalJSON(b []byte) error {
+	var raw []any
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	var ob opBuilder
+	for _, r := range raw {
+		switch v := r.(type) {
+		case string:
+			ob.insert(v)
+		case float64:
+			if v == 0 || v > opMaxLen || v < -opMaxLen || v != float64(int(v)) {
+				return fmt.Errorf("invalid operation component %v", v)
+			}
+			if v > 0 {
+				ob.retain(int(v))
+			} else {
+				ob.delete(int(-v))
+			}
+		default:
+			return fmt.Errorf("invalid operation component %v", v)
+		}
+	}
+	if ob.err != nil || ob.op.baseLen() < 0 {
+		return errOpBaseLength
+	}
+	*op = ob.op
+	return nil
+}
+
+// baseLen is the length of the documents op applies to, -1 when it exceeds opMaxLen
+func (op textOp) baseLen() int {
+	n := 0
+	for _, c := range op {
+		if !c.isInsert() {
+			if abs(c.n) > opMaxLen-n {
+				return -1
+			}
+			n += abs(c.n)
+		}
+	}
+	return n
+}
+
+// apply returns doc with op applied
+func (op textOp) apply(doc string) (string, error) {
+	src := utf16.Encode([]rune(doc))
+	if op.baseLen() != len(src) {
+		return "", errOpBaseLength
+	}
+	out := make([]uint16, 0, len(src))
+	pos := 0
+	for _, c := range op {
+		if !c.isInsert() && abs(c.n) > len(src)-pos {
+			return "", errOpBaseLength
+		}
+		switch {
+		case c.isInsert():
+			out = append(out, utf16.Encode([]rune(c.s))...)
+		case c.isRetain():
+			out = append(out, src[pos:pos+c.n]...)
+			pos += c.n
+		default:
+			pos -= c.n
+		}
+	}
+	return string(utf16.Decode(out)), nil
+}
+
+// transformOp transforms concurrent operations a and b on the same document into a' and b'
+// so that applying a then b' gives the same document as b then a', inserts of a go first
+func transformOp(a, b textOp) (textOp, textOp, error) {
+	if a.baseLen() != b.baseLen() || a.baseLen() < 0 {
+		return nil, nil, errOpBaseLength
+	}
+	var a1, b1 opBuilder
+	i, j := 0, 0
+	var c1, c2 *opComponent
+	next := func(op textOp, k *int) *opComponent {
+		if *k >= len(op) {
+			return nil
+		}
+		c := op[*k]
+		*k++
+		return &c
+	}
+	c1, c2 = next(a, &i), next(b, &j)
+	for c1 != nil || c2 != nil {
+		if c1 != nil && c1.isInsert() {
+			a1.insert(c1.s)
+			b1.retain(utf16Len(c1.s))
+			c1 = next(a, &i)
+			continue
+		}
+		if c2 != nil && c2.isInsert() {
+			a1.retain(utf16Len(c2.s))
+			b1.insert(c2.s)
+			c2 = next(b, &j)
+			continue
+		}
+		if c1 == nil || c2 == nil {
+			return nil, nil, errOpBaseLength
+		}
+
+		n := abs(c1.n)
+		if abs(c2.n) < n {
+			n = abs(c2.n)
+		}
+		switch {
+		case c1.isRetain() && c2.isRetain():
+			a1.retain(n)
+			b1.retain(n)
+		case c1.isDelete() && c2.isRetain():
+			a1.delete(n)
+		case c1.isRetain() && c2.isDelete():
+			b1.delete(n)
+		}
+		// both deleting the same range leaves nothing to do
+		c1 = consume(c1, n, a, &i, next)
+		c2 = consume(c2, n, b, &j, next)
+	}
+	if a1.err != nil || b1.err != nil {
+		return nil, nil, errOpBaseLength
+	}
+	return a1.op, b1.op, nil
+}
+
+// consume shortens a retain or delete by n and moves on once it is used up
+func consume(c *opComponent, n int, op textOp, k *int, next func(textOp, *int) *opComponent) *opComponent {
+	if abs(c.n) == n {
+		return next(op, k)
+	}
+	if c.n > 0 {
+		c.n -= n
+	} else {
+		c.n += n
+	}
+	return c
+}
+
+// opBuilder appends components while merging neighbours the way ot.js normalises operations,
+// err is set once a merged component would exceed opMaxLen
+type opBuilder struct {
+	op  textOp
+	err error
+}
+
+func (b *opBuilder) retain(n int) {
+	if n == 0 {
+		return
+	}
+	if n < 0 || n > opMaxLen {
+		b.err = errOpBaseLength
+		return
+	}
+	if last := len(b.op) - 1; last >= 0 && b.op[last].isRetain() {
+		if b.op[last].n > opMaxLen-n {
+			b.err = errOpBaseLength
+			return
+		}
+		b.op[last].n += n
+		return
+	}
+	b.op = append(b.op, opComponent{n: n})
+}
+
+func (b *opBuilder) delete(n int) {
+	if n == 0 {
+		return
+	}
+	if n < 0 || n > opMaxLen {
+		b.err = errOpBaseLength
+		return
+	}
+	if last := len(b.op) - 1; last >= 0 && b.op[last].isDelete() {
+		if -b.op[last].n > opMaxLen-n {
+			b.err = errOpBaseLength
+			return
+		}
+		b.op[last].n -= n
+		return
+	}
+	b.op = append(b.op, opComponent{n: -n})
+}
+
+// insert keeps inserts in front of a directly preceding delete
+func (b *opBuilder) insert(s string) {
+	if s == "" {
+		return
+	}
+	last := len(b.op) - 1
+	if last >= 0 && b.op[last].isInsert() {
+		b.op[last].s += s
+		return
+	}
+	if last >= 0 && b.op[last].isDelete() {
+		if last > 0 && b.op[last-1].isInsert() {
+			b.op[last-1].s += s
+			return
+		}
+		b.op = append(b.op, b.op[last])
+		b.op[last] = opComponent{s: s}
+		return
+	}
+	b.op = append(b.op, opComponent{s: s})
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"encoding/json"
+	"math/rand"
+	"testing"
+	"unicode/utf16"
+)
+
+func randomOp(r *rand.Rand, doc string) textOp {
+	var b opBuilder
+	n := len(utf16.Encode([]rune(doc)))
+	for n > 0 {
+		k := 1 + r.Intn(n)
+		switch r.Intn(4) {
+		case 0:
+			b.insert([]string{"a", "xy", "中", "😀"}[r.Intn(4)])
+		case 1:
+			b.delete(k)
+			n -= k
+		default:
+			b.retain(k)
+			n -= k
+		}
+	}
+	if r.Intn(2) == 0 {
+		b.insert("z")
+	}
+	return b.op
+}
+
+func TestTransformOpConverges(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		doc := []string{"", "hello world", "中文 text 😀 here", "line one\nline two\n"}[i%4]
+		a, b := randomOp(r, doc), randomOp(r, doc)
+		a1, b1, err := transformOp(a, b)
+		if err != nil {
+			t.Fatalf("transformOp(%v, %v): %s", a, b, err)
+		}
+		da, err := a.apply(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db, err := b.apply(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		left, err := b1.apply(da)
+		if err != nil {
+			t.Fatalf("apply b' after a: %s", err)
+		}
+		right, err := a1.apply(db)
+		if err != nil {
+			t.Fatalf("apply a' after b: %s", err)
+		}
+		if left != right {
+			t.Fatalf("doc %q, a %v, b %v: %q != %q", doc, a, b, left, right)
+		}
+	}
+}
+
+func TestTextOpJSON(t *testing.T) {
+	var op textOp
+	if err := json.Unmarshal([]byte(`[2, "ab", -1, 1, 1]`), &op); err != nil {
+		t.Fatal(err)
+	}
+	got, err := op.apply("abcde")
+	if err != nil || got != "ababde" {
+		t.Errorf("apply = %q, %v", got, err)
+	}
+	b, _ := json.Marshal(op)
+	if string(b) != `[2,"ab",-1,2]` {
+		t.Errorf("marshal = %s", b)
+	}
+	if err = json.Unmarshal([]byte(`[1.5]`), &op); err == nil {
+		t.Error("fractional component accepted")
+	}
+	if _, err = op.apply("abc"); err == nil {
+		t.Error("applied to a document of the wrong length")
+	}
+}
+
+func TestTextOpOverflow(t *testing.T) {
+	// merged retains and deletes would wrap around to the length of "hello"
+	for _, raw := range []string{
+		`[4611686018427387904,"x",-4611686018427387904,-4611686018427387904,4611686018427387904,"y",5]`,
+		`[2147483647, 2147483647, -2147483647, -2147483647]`,
+		`[-2147483648]`,
+	} {
+		var op textOp
+		if err := json.Unmarshal([]byte(raw), &op); err == nil {
+			t.Errorf("accepted %s as %v", raw, op)
+		}
+	}
+
+	if _, err := (textOp{{n: 6}, {n: -1}}).apply("hello"); err == nil {
+		t.Error("applied an op longer than the document")
+	}
+}
+
+func TestReplaceOp(t *testing.T) {
+	cases := [][2]string{
+		{"", ""},
+		{"", "abc"},
+		{"abc", ""},
+		{"hello world", "hello brave world"},
+		{"aaa", "aa"},
+		{"中文😀", "中😀文😀"},
+	}
+	for _, tc := range cases {
+		got, err := replaceOp(tc[0], tc[1]).apply(tc[0])
+		if err != nil || got != tc[1] {
+			t.Errorf("replaceOp(%q, %q) gives %q, %v", tc[0], tc[1], got, err)
+		}
+	}
+}
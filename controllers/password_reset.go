@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+	"hammer-web-api/di"
+	"hammer-web-api/models"
+	"net/http"
+	"time"
+)
+
+const (
+	resetCodeInterval = time.Minute
+	resetMaxAttempts  = 5
+)
+
+type resetCodeForm struct {
+	Phone     string `json:"phone" binding:"required,len=11,numeric"`
+	CaptchaID string `json:"captchaID" binding:"required"`
+	Captcha   string `json:"captcha" binding:"required"`
+}
+
+type resetPasswordForm struct {
+	Phone    string `json:"phone" binding:"required,len=11,numeric"`
+	Code     string `json:"code" binding:"required"`
+	Password string `json:"password" binding:"required,min=6,max=72"`
+}
+
+// PostResetCode sends a password reset code, the answer is the same whether the phone
+// is registered or not so it cannot be used to find accounts
+func (t *UserController) PostResetCode(c *gin.Context) {
+	rf := resetCodeForm{}
+	if err := c.ShouldBindJSON(&rf); err != nil {
+		if fields, ok := fieldErrors(err); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry", "errors": fields})
+			return
+		}
+		di.Zap().Errorf("failed to bind form: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+		return
+	}
+	if ok := store.Verify(rf.CaptchaID, rf.Captcha, true); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "wrong captcha"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	// one code per phone and interval
+	sent, err := di.GoRedis().SetNX(ctx, resetThrottleKey(rf.Phone), 1, resetCodeInterval).Result()
+	if err != nil {
+		di.Zap().Errorf("failed to throttle reset code of %s: %s", rf.Phone, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	if !sent {
+		c.JSON(http.StatusTooManyRequests, gin.H{"message": "please wait before requesting another code"})
+		return
+	}
+
+	if di.Gorm().Select("id").Where("phone = ?", rf.Phone).First(&models.User{}).RowsAffected > 0 {
+		code := generateSmsCode(6)
+		if err = sendSmsCode(rf.Phone, code); err != nil {
+			di.Zap().Errorf("failed to send sms: %s", err)
+			di.GoRedis().Del(ctx, resetThrottleKey(rf.Phone))
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to send verification code"})
+			return
+		}
+		ttl := contactCodeTTL()
+		_, err = di.GoRedis().TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.Set(ctx, resetCodeKey(rf.Phone), code, ttl)
+			p.Del(ctx, resetAttemptsKey(rf.Phone))
+			return nil
+		})
+		if err != nil {
+			di.Zap().Errorf("failed to save reset code of %s: %s", rf.Phone, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+	})
+}
+
+// ResetPassword sets a new password with a reset code and signs the user out everywhere
+func (t *UserController) ResetPassword(c *gin.Context) {
+	rf := resetPasswordForm{}
+	if err := c.ShouldBindJSON(&rf); err != nil {
+		if fields, ok := fieldErrors(err); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry", "errors": fields})
+			return
+		}
+		di.Zap().Errorf("failed to bind form: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	// attempts are counted per code, a new code starts over
+	attempts, err := di.GoRedis().Incr(ctx, resetAttemptsKey(rf.Phone)).Result()
+	if err == nil && attempts == 1 {
+		err = di.GoRedis().Expire(ctx, resetAttemptsKey(rf.Phone), contactCodeTTL()).Err()
+	}
+	if err != nil {
+		di.Zap().Errorf("failed to count reset attempts of %s: %s", rf.Phone, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	if attempts > resetMaxAttempts {
+		di.GoRedis().Del(ctx, resetCodeKey(rf.Phone))
+		c.JSON(http.StatusTooManyRequests, gin.H{"message": "too many attempts, please request a new code"})
+		return
+	}
+
+	code, err := di.GoRedis().Get(ctx, resetCodeKey(rf.Phone)).Result()
+	if err != nil && err != redis.Nil {
+		di.Zap().Errorf("failed to get reset code of %s: %s", rf.Phone, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	if code == "" || subtle.ConstantTimeCompare([]byte(code), []byte(rf.Code)) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "wrong or expired code"})
+		return
+	}
+	// whoever deletes the code uses it
+	if n, err := di.GoRedis().Del(ctx, resetCodeKey(rf.Phone)).Result(); err != nil || n == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "wrong or expired code"})
+		return
+	}
+	di.GoRedis().Del(ctx, resetAttemptsKey(rf.Phone))
+
+	user := models.User{}
+	if di.Gorm().Select("id").Where("phone = ?", rf.Phone).First(&user).RowsAffected == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "wrong or expired code"})
+		return
+	}
+	hashPWD, err := bcrypt.GenerateFromPassword([]byte(rf.Password), bcrypt.DefaultCost)
+	if err != nil {
+		di.Zap().Errorf("failed to hash password: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	if res := di.Gorm().Model(&user).Update("password", string(hashPWD)); res.Error != nil {
+		di.Zap().Errorf("failed to reset password of user %d: %s", user.ID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	// a reset is only done once the old sessions are gone
+	for i := 0; i < 3; i++ {
+		if err = di.RevokeUserTokens(ctx, user.ID); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		di.Zap().Errorf("failed to revoke tokens of user %d: %s", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+	})
+}
+
+func resetCodeKey(phone string) string {
+	return fmt.Sprintf("reset:code:%s", phone)
+}
+
+func resetAttemptsKey(phone string) string {
+	return fmt.Sprintf("reset:attempts:%s", phone)
+}
+
+func resetThrottleKey(phone string) string {
+	return fmt.Sprintf("reset:throttle:%s", phone)
+}
@@ -0,0 +1,313 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"hammer-web-api/di"
+	"hammer-web-api/models"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+var errPrerequisiteCycle = errors.New("prerequisites form a cycle")
+
+type prerequisiteForm struct {
+	RequiredID uint `json:"requiredID" binding:"required"`
+}
+
+func (t *TextbookController) PostPrerequisite(c *gin.Context) {
+	tid, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid textbook id"})
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	pf := prerequisiteForm{}
+	if err = c.ShouldBindJSON(&pf); err != nil {
+		di.Zap().Errorf("failed to bind form: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+		return
+	}
+	if pf.RequiredID == uint(tid) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "a textbook cannot require itself"})
+		return
+	}
+
+	edge := models.TextbookPrerequisite{TextbookID: uint(tid), RequiredID: pf.RequiredID}
+	err = di.Gorm().Transaction(func(tx *gorm.DB) error {
+		// lock both textbooks in id order so concurrent declarations are checked one after another
+		var textbooks []models.Textbook
+		res := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "author_id", "collaborator_id", "is_private").
+			Where("id IN ?", []uint{edge.TextbookID, edge.RequiredID}).Order("id").Find(&textbooks)
+		if res.Error != nil {
+			return res.Error
+		}
+		if len(textbooks) != 2 {
+			return gorm.ErrRecordNotFound
+		}
+		for _, tb := range textbooks {
+			if tb.ID == edge.TextbookID && tb.AuthorID != userID {
+				return errNoPermission
+			}
+			if tb.ID == edge.RequiredID && !textbookReadable(&tb, userID) {
+				return gorm.ErrRecordNotFound
+			}
+		}
+
+		// the new edge closes a cycle if the required textbook already leads back to this one
+		graph, err := loadPrerequisiteGraph(tx, edge.RequiredID)
+		if err != nil {
+			return err
+		}
+		if path := prerequisitePath(graph, edge.RequiredID, edge.TextbookID); path != nil {
+			return &prerequisiteCycleError{path: append([]uint{edge.TextbookID}, path...)}
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&edge).Error
+	})
+	var cycle *prerequisiteCycleError
+	switch {
+	case errors.As(err, &cycle):
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "prerequisites would form a cycle",
+			"data":    gin.H{"cycle": cycle.path},
+		})
+	case errors.Is(err, errNoPermission):
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"message": "textbook not found"})
+	case err != nil:
+		di.Zap().Errorf("failed to add prerequisite to textbook %d: %s", tid, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+	default:
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "OK",
+			"data":    edge,
+		})
+	}
+}
+
+func (t *TextbookController) DeletePrerequisite(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	tid, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid textbook id"})
+		return
+	}
+	if di.Gorm().Where("id = ? AND author_id = ?", tid, userID).First(&models.Textbook{}).RowsAffected == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return
+	}
+
+	res := di.Gorm().Where("textbook_id = ? AND required_id = ?", tid, c.Param("rid")).Delete(&models.TextbookPrerequisite{})
+	if res.Error != nil {
+		di.Zap().Errorf("failed to delete prerequisite of textbook %d: %s", tid, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "prerequisite not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+	})
+}
+
+func (t *TextbookController) GetPrerequisites(c *gin.Context) {
+	tid, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid textbook id"})
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	graph, err := loadPrerequisiteGraph(di.Gorm(), uint(tid))
+	if err == nil {
+		var order []uint
+		if order, err = prerequisiteOrder(graph, uint(tid)); err == nil {
+			t.respondPrerequisites(c, userID, uint(tid), graph[uint(tid)], order)
+			return
+		}
+	}
+	di.Zap().Errorf("failed to compute prerequisites of textbook %d: %s", tid, err)
+	c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+}
+
+func (t *TextbookController) respondPrerequisites(c *gin.Context, userID, tid uint, direct, order []uint) {
+	var textbooks []models.Textbook
+	res := di.Gorm().Select("id", "title", "author_id", "collaborator_id", "is_private").Where("id IN ?", order).Find(&textbooks)
+	if res.Error != nil {
+		di.Zap().Errorf("failed to query textbooks: %s", res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	titles := make(map[uint]string, len(textbooks))
+	for _, tb := range textbooks {
+		if tb.ID == tid && !textbookReadable(&tb, userID) {
+			c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+			return
+		}
+		// private prerequisites the user cannot read are left out
+		if textbookReadable(&tb, userID) {
+			titles[tb.ID] = tb.Title
+		}
+	}
+	if _, ok := titles[tid]; !ok {
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("textbook %d not found", tid)})
+		return
+	}
+	direct, order = readableIDs(direct, titles), readableIDs(order, titles)
+	progress, err := readingProgress(userID, order)
+	if err != nil {
+		di.Zap().Errorf("failed to query reading progress: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	directData := make([]gin.H, 0, len(direct))
+	for _, id := range direct {
+		directData = append(directData, gin.H{"id": id, "title": titles[id]})
+	}
+	orderData := make([]gin.H, 0, len(order))
+	unfinished := make([]gin.H, 0)
+	for _, id := range order {
+		p := progress[id]
+		item := gin.H{"id": id, "title": titles[id], "percent": p.Percent, "completed": isCompleted(p)}
+		orderData = append(orderData, item)
+		if id != tid && !isCompleted(p) {
+			unfinished = append(unfinished, item)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data": gin.H{
+			"prerequisites": directData,
+			"readingOrder":  orderData,
+			"unfinished":    unfinished,
+		},
+	})
+}
+
+// textbookReadable reports whether a textbook is public or the user is its author or collaborator
+func textbookReadable(textbook *models.Textbook, userID uint) bool {
+	return !textbook.IsPrivate || textbook.AuthorID == userID ||
+		(textbook.CollaboratorID != nil && *textbook.CollaboratorID == userID)
+}
+
+func readableIDs(ids []uint, titles map[uint]string) []uint {
+	out := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := titles[id]; ok {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+type prerequisiteCycleError struct {
+	path []uint
+}
+
+func (e *prerequisiteCycleError) Error() string {
+	return fmt.Sprintf("%s: %v", errPrerequisiteCycle, e.path)
+}
+
+// loadPrerequisiteGraph loads every prerequisite edge reachable from start, keyed by the requiring textbook
+func loadPrerequisiteGraph(db *gorm.DB, start uint) (map[uint][]uint, error) {
+	graph := make(map[uint][]uint)
+	seen := map[uint]bool{start: true}
+	frontier := []uint{start}
+	for len(frontier) > 0 {
+		var edges []models.TextbookPrerequisite
+		if err := db.Where("textbook_id IN ?", frontier).Order("required_id").Find(&edges).Error; err != nil {
+			return nil, err
+		}
+		frontier = frontier[:0]
+		for _, e := range edges {
+			graph[e.TextbookID] = append(graph[e.TextbookID], e.RequiredID)
+			if !seen[e.RequiredID] {
+				seen[e.RequiredID] = true
+				frontier = append(frontier, e.RequiredID)
+			}
+		}
+	}
+	return graph, nil
+}
+
+// prerequisitePath returns a chain of prerequisites leading from from to to, or nil if there is none
+func prerequisitePath(graph map[uint][]uint, from, to uint) []uint {
+	parent := map[uint]uint{from: from}
+	queue := []uint{from}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if node == to {
+			var path []uint
+			for ; node != from; node = parent[node] {
+				path = append(path, node)
+			}
+			path = append(path, from)
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+			return path
+		}
+		for _, next := range graph[node] {
+			if _, ok := parent[next]; !ok {
+				parent[next] = node
+				queue = append(queue, next)
+			}
+		}
+	}
+	return nil
+}
+
+// prerequisiteOrder sorts target and all its transitive prerequisites so that
+// every textbook comes after the ones it requires, ties are broken by id
+func prerequisiteOrder(graph map[uint][]uint, target uint) ([]uint, error) {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[uint]int)
+	var order []uint
+	var visit func(node uint) error
+	visit = func(node uint) error {
+		switch state[node] {
+		case visiting:
+			return errPrerequisiteCycle
+		case done:
+			return nil
+		}
+		state[node] = visiting
+		required := append([]uint(nil), graph[node]...)
+		sort.Slice(required, func(i, j int) bool { return required[i] < required[j] })
+		for _, r := range required {
+			if err := visit(r); err != nil {
+				return err
+			}
+		}
+		state[node] = done
+		order = append(order, node)
+		return nil
+	}
+	if err := visit(target); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
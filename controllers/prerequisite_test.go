@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"errors"
+	"hammer-web-api/models"
+	"reflect"
+	"testing"
+)
+
+func TestPrerequisiteOrder(t *testing.T) {
+	// 5 requires 3 and 4, both require 2, which requires 1
+	graph := map[uint][]uint{
+		5: {4, 3},
+		4: {2},
+		3: {2},
+		2: {1},
+	}
+	order, err := prerequisiteOrder(graph, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint{1, 2, 3, 4, 5}; !reflect.DeepEqual(order, want) {
+		t.Errorf("expected %v, got %v", want, order)
+	}
+
+	order, err = prerequisiteOrder(graph, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint{1}; !reflect.DeepEqual(order, want) {
+		t.Errorf("expected %v, got %v", want, order)
+	}
+
+	graph[1] = []uint{5}
+	if _, err = prerequisiteOrder(graph, 5); !errors.Is(err, errPrerequisiteCycle) {
+		t.Errorf("expected a cycle error, got %v", err)
+	}
+}
+
+func TestPrerequisitePath(t *testing.T) {
+	graph := map[uint][]uint{
+		3: {2},
+		2: {1},
+	}
+	// declaring that 1 requires 3 would close 1 -> 3 -> 2 -> 1
+	if path := prerequisitePath(graph, 3, 1); !reflect.DeepEqual(path, []uint{3, 2, 1}) {
+		t.Errorf("expected path 3 2 1, got %v", path)
+	}
+	if path := prerequisitePath(graph, 1, 3); path != nil {
+		t.Errorf("expected no path, got %v", path)
+	}
+}
+
+func TestTextbookReadable(t *testing.T) {
+	collaborator := uint(3)
+	textbook := &models.Textbook{AuthorID: 2, CollaboratorID: &collaborator, IsPrivate: true}
+	for userID, want := range map[uint]bool{2: true, 3: true, 4: false} {
+		if got := textbookReadable(textbook, userID); got != want {
+			t.Errorf("user %d: readable = %v, want %v", userID, got, want)
+		}
+	}
+	textbook.IsPrivate = false
+	if !textbookReadable(textbook, 4) {
+		t.Error("public textbook not readable")
+	}
+}
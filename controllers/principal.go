@@ -0,0 +1,17 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"hammer-web-api/middleware"
+	"net/http"
+)
+
+// currentUserID is the user AuthMiddleware authenticated the request as
+func currentUserID(c *gin.Context) (uint, bool) {
+	principal, ok := middleware.CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "failed to get principal"})
+		return 0, false
+	}
+	return principal.UserID, true
+}
@@ -0,0 +1,397 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"hammer-web-api/di"
+	"hammer-web-api/models"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type ProposalController struct {
+}
+
+type proposalForm struct {
+	BaseVersionID uint   `json:"baseVersionID"`
+	Title         string `json:"title" binding:"required,max=100"`
+	Description   string `json:"description"`
+	Content       string `json:"content" binding:"required,max=1000000"`
+}
+
+type proposalCommentForm struct {
+	Content  string `json:"content" binding:"required,max=5000"`
+	Line     *uint  `json:"line"`
+	ParentID *uint  `json:"parentID"`
+}
+
+type proposalReviewForm struct {
+	Decision string `json:"decision" binding:"required,oneof=approve reject"`
+}
+
+func (t *ProposalController) Post(c *gin.Context) {
+	textbook, userID, ok := loadProposalTextbook(c)
+	if !ok {
+		return
+	}
+	if !canPropose(textbook, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "only collaborators and forkers can propose changes"})
+		return
+	}
+	pf := proposalForm{}
+	if err := c.ShouldBindJSON(&pf); err != nil {
+		di.Zap().Errorf("failed to bind form: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+		return
+	}
+
+	// the base defaults to the latest version
+	var base models.TextbookVersion
+	query := di.Gorm().Select("id", "no").Where("textbook_id = ?", textbook.ID)
+	if pf.BaseVersionID != 0 {
+		query = query.Where("id = ?", pf.BaseVersionID)
+	}
+	if res := query.Order("created_at DESC").First(&base); res.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "base version not found"})
+		return
+	}
+
+	proposal := models.Proposal{
+		Title:         pf.Title,
+		Description:   pf.Description,
+		Content:       pf.Content,
+		Status:        models.ProposalOpen,
+		TextbookID:    textbook.ID,
+		BaseVersionID: base.ID,
+		ProposerID:    userID,
+	}
+	if res := di.Gorm().Create(&proposal); res.Error != nil {
+		di.Zap().Errorf("failed to create proposal: %s", res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "OK",
+		"data":    proposal,
+	})
+}
+
+func (t *ProposalController) GetList(c *gin.Context) {
+	textbook, _, ok := loadProposalTextbook(c)
+	if !ok {
+		return
+	}
+
+	var proposals []models.Proposal
+	query := di.Gorm().Omit("content").Where("textbook_id = ?", textbook.ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if res := query.Order("created_at DESC").Find(&proposals); res.Error != nil {
+		di.Zap().Errorf("failed to query proposals of textbook %d: %s", textbook.ID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    proposals,
+	})
+}
+
+func (t *ProposalController) Get(c *gin.Context) {
+	textbook, _, ok := loadProposalTextbook(c)
+	if !ok {
+		return
+	}
+	proposal, ok := loadProposal(c, textbook)
+	if !ok {
+		return
+	}
+
+	var base models.TextbookVersion
+	err := di.Gorm().First(&base, proposal.BaseVersionID).Error
+	if err == nil {
+		err = loadVersionContent(di.Gorm(), &base)
+	}
+	if err != nil {
+		di.Zap().Errorf("failed to query base version %d: %s", proposal.BaseVersionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	var comments []models.ProposalComment
+	di.Gorm().Where("proposal_id = ?", proposal.ID).Order("created_at").Find(&comments)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data": gin.H{
+			"proposal":    proposal,
+			"baseVersion": base.No,
+			"diff":        unifiedDiff(base.Content, proposal.Content, 3),
+			"comments":    comments,
+		},
+	})
+}
+
+func (t *ProposalController) PostComment(c *gin.Context) {
+	textbook, userID, ok := loadProposalTextbook(c)
+	if !ok {
+		return
+	}
+	proposal, ok := loadProposal(c, textbook)
+	if !ok {
+		return
+	}
+	if !canPropose(textbook, userID) && proposal.ProposerID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return
+	}
+	cf := proposalCommentForm{}
+	if err := c.ShouldBindJSON(&cf); err != nil {
+		di.Zap().Errorf("failed to bind form: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+		return
+	}
+
+	// replies must stay within the same proposal
+	if cf.ParentID != nil && di.Gorm().Where("id = ? AND proposal_id = ?", *cf.ParentID, proposal.ID).
+		First(&models.ProposalComment{}).RowsAffected == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "parent comment not found"})
+		return
+	}
+
+	comment := models.ProposalComment{
+		Content:    cf.Content,
+		Line:       cf.Line,
+		ParentID:   cf.ParentID,
+		ProposalID: proposal.ID,
+		AuthorID:   userID,
+	}
+	if res := di.Gorm().Create(&comment); res.Error != nil {
+		di.Zap().Errorf("failed to create proposal comment: %s", res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	if comment.ParentID != nil {
+		notifyCommentReply(comment, textbook.ID)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "OK",
+		"data":    comment,
+	})
+}
+
+func (t *ProposalController) Review(c *gin.Context) {
+	textbook, userID, ok := loadProposalTextbook(c)
+	if !ok {
+		return
+	}
+	if textbook.AuthorID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"message": "only the author can review proposals"})
+		return
+	}
+	proposal, ok := loadProposal(c, textbook)
+	if !ok {
+		return
+	}
+	rf := proposalReviewForm{}
+	if err := c.ShouldBindJSON(&rf); err != nil {
+		di.Zap().Errorf("failed to bind form: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+		return
+	}
+	// a proposal is reviewed once, open to approved or rejected
+	if proposal.Status != models.ProposalOpen {
+		c.JSON(http.StatusConflict, gin.H{"message": fmt.Sprintf("proposal is already %s", proposal.Status)})
+		return
+	}
+
+	status := models.ProposalApproved
+	if rf.Decision == "reject" {
+		status = models.ProposalRejected
+	}
+	res := di.Gorm().Model(&proposal).Where("status = ?", models.ProposalOpen).
+		Updates(map[string]any{"status": status, "reviewer_id": userID})
+	if res.Error != nil {
+		di.Zap().Errorf("failed to review proposal %d: %s", proposal.ID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"message": "proposal has already been reviewed"})
+		return
+	}
+	proposal.Status = status
+	proposal.ReviewerID = &userID
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    proposal,
+	})
+}
+
+func (t *ProposalController) Merge(c *gin.Context) {
+	textbook, userID, ok := loadProposalTextbook(c)
+	if !ok {
+		return
+	}
+	if textbook.AuthorID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"message": "only the author can merge proposals"})
+		return
+	}
+	proposal, ok := loadProposal(c, textbook)
+	if !ok {
+		return
+	}
+	if proposal.Status != models.ProposalApproved {
+		c.JSON(http.StatusConflict, gin.H{"message": "only approved proposals can be merged"})
+		return
+	}
+
+	var version models.TextbookVersion
+	err := di.Gorm().Transaction(func(tx *gorm.DB) error {
+		var latest models.TextbookVersion
+		if err := latestVersionLocked(tx, textbook.ID, &latest); err != nil {
+			return err
+		}
+		// someone published after the proposal was based
+		if latest.ID != proposal.BaseVersionID {
+			if err := loadVersionContent(tx, &latest); err != nil {
+				return err
+			}
+			return &mergeConflictError{latest: latest}
+		}
+
+		version = models.TextbookVersion{
+			No:         nextVersionNo(latest.No),
+			Content:    proposal.Content,
+			TextbookID: textbook.ID,
+		}
+		if err := saveVersion(tx, &version); err != nil {
+			return err
+		}
+		return tx.Model(&proposal).Updates(map[string]any{
+			"status":            models.ProposalMerged,
+			"merged_version_id": version.ID,
+		}).Error
+	})
+	var conflict *mergeConflictError
+	if errors.As(err, &conflict) {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "base version is no longer the latest, please rebase the proposal",
+			"data": gin.H{
+				"latestVersionID": conflict.latest.ID,
+				"latestVersion":   conflict.latest.No,
+				"diff":            unifiedDiff(conflict.latest.Content, proposal.Content, 3),
+			},
+		})
+		return
+	}
+	if err != nil {
+		di.Zap().Errorf("failed to merge proposal %d: %s", proposal.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	textbookCache.Invalidate(context.Background(), textbook.ID)
+	notifyNewVersion(textbook.ID, version.ID, userID, version.No)
+	publishCollaboratorActivity(*textbook, userID, "proposal.merged", gin.H{"vid": version.ID, "version": version.No, "proposalID": proposal.ID})
+	emitWebhookEvent(models.WebhookVersionPublished, *textbook, gin.H{"vid": version.ID, "version": version.No, "proposalID": proposal.ID})
+	recordTextbookActivity(*textbook, &version)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data": gin.H{
+			"vid":     version.ID,
+			"version": version.No,
+		},
+	})
+}
+
+type mergeConflictError struct {
+	latest models.TextbookVersion
+}
+
+func (e *mergeConflictError) Error() string {
+	return fmt.Sprintf("latest version is %s", e.latest.No)
+}
+
+// loadProposalTextbook loads the textbook of the route and the requesting user id
+func loadProposalTextbook(c *gin.Context) (*models.Textbook, uint, bool) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return nil, 0, false
+	}
+	tid, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid textbook id"})
+		return nil, 0, false
+	}
+	var textbook models.Textbook
+	if res := di.Gorm().First(&textbook, tid); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("textbook %d not found", tid)})
+		} else {
+			di.Zap().Errorf("failed to query textbook: %s", res.Error)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		}
+		return nil, 0, false
+	}
+	if textbook.IsPrivate && !canPropose(&textbook, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return nil, 0, false
+	}
+	return &textbook, userID, true
+}
+
+func loadProposal(c *gin.Context, textbook *models.Textbook) (models.Proposal, bool) {
+	var proposal models.Proposal
+	pid, err := strconv.ParseUint(c.Param("pid"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid proposal id"})
+		return proposal, false
+	}
+	res := di.Gorm().Where("id = ? AND textbook_id = ?", pid, textbook.ID).First(&proposal)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("proposal %d not found", pid)})
+		} else {
+			di.Zap().Errorf("failed to query proposal: %s", res.Error)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		}
+		return proposal, false
+	}
+	return proposal, true
+}
+
+// canPropose reports whether the user is the author, the collaborator or a forker of the textbook
+func canPropose(textbook *models.Textbook, userID uint) bool {
+	if textbook.AuthorID == userID {
+		return true
+	}
+	if textbook.CollaboratorID != nil && *textbook.CollaboratorID == userID {
+		return true
+	}
+	return di.Gorm().Select("id").
+		Where("forked_from_id = ? AND author_id = ?", textbook.ID, userID).
+		First(&models.Textbook{}).RowsAffected > 0
+}
+
+// nextVersionNo bumps the patch number of a version like 1.2.3
+func nextVersionNo(no string) string {
+	parts := strings.Split(no, ".")
+	if len(parts) != 3 {
+		return "1.0.0"
+	}
+	patch, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return "1.0.0"
+	}
+	return fmt.Sprintf("%s.%s.%d", parts[0], parts[1], patch+1)
+}
@@ -0,0 +1,521 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"hammer-web-api/di"
+	"hammer-web-api/models"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type QuizController struct {
+}
+
+type quizForm struct {
+	Title     string         `json:"title" binding:"required,max=100"`
+	Section   string         `json:"section" binding:"max=255"`
+	Questions []questionForm `json:"questions" binding:"required,min=1,dive"`
+}
+
+type questionForm struct {
+	Kind        string          `json:"kind" binding:"required,oneof=single multi fill truefalse"`
+	Prompt      string          `json:"prompt" binding:"required"`
+	Options     []string        `json:"options"`
+	Answer      json.RawMessage `json:"answer" binding:"required"`
+	Explanation string          `json:"explanation"`
+}
+
+type attemptForm struct {
+	Answers []struct {
+		QuestionID uint            `json:"questionID" binding:"required"`
+		Answer     json.RawMessage `json:"answer"`
+	} `json:"answers" binding:"required,dive"`
+}
+
+func (t *QuizController) Post(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	textbook, version, ok := loadQuizVersion(c, userID)
+	if !ok {
+		return
+	}
+	if textbook.AuthorID != userID && (textbook.CollaboratorID == nil || *textbook.CollaboratorID != userID) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return
+	}
+	qf := quizForm{}
+	if err := c.ShouldBindJSON(&qf); err != nil {
+		di.Zap().Errorf("failed to bind form: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+		return
+	}
+	// a section names a heading of the version the quiz is attached to
+	if qf.Section = strings.TrimSpace(qf.Section); qf.Section != "" {
+		content := models.TextbookVersion{}
+		err := di.Gorm().Select("id", "content", "blob_hash").First(&content, version.ID).Error
+		if err == nil {
+			err = loadVersionContent(di.Gorm(), &content)
+		}
+		if err != nil {
+			di.Zap().Errorf("failed to load version %d: %s", version.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+			return
+		}
+		if !containsString(markdownHeadings(content.Content), qf.Section) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": fmt.Sprintf("section %q is not a heading of version %s", qf.Section, version.No),
+				"errors":  gin.H{"section": "heading"},
+			})
+			return
+		}
+	}
+
+	quiz := models.Quiz{
+		Title:             qf.Title,
+		Section:           qf.Section,
+		TextbookVersionID: version.ID,
+		AuthorID:          userID,
+	}
+	for i, f := range qf.Questions {
+		answer, err := normalizeAnswer(f.Answer)
+		q := models.QuizQuestion{
+			Position:    uint(i),
+			Kind:        f.Kind,
+			Prompt:      f.Prompt,
+			Options:     f.Options,
+			Answer:      answer,
+			Explanation: f.Explanation,
+		}
+		if err == nil {
+			err = validateQuestion(&q)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("question %d: %s", i, err)})
+			return
+		}
+		quiz.Questions = append(quiz.Questions, q)
+	}
+
+	if res := di.Gorm().Create(&quiz); res.Error != nil {
+		di.Zap().Errorf("failed to create quiz: %s", res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "OK",
+		"data":    quiz,
+	})
+}
+
+func (t *QuizController) GetList(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	_, version, ok := loadQuizVersion(c, userID)
+	if !ok {
+		return
+	}
+
+	var quizzes []models.Quiz
+	res := di.Gorm().Preload("Questions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).Where("textbook_version_id = ?", version.ID).Find(&quizzes)
+	if res.Error != nil {
+		di.Zap().Errorf("failed to query quizzes of version %d: %s", version.ID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    quizzes,
+	})
+}
+
+func (t *QuizController) Get(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	quiz, _, ok := loadQuiz(c, userID)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    quiz,
+	})
+}
+
+func (t *QuizController) PostAttempt(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	quiz, _, ok := loadQuiz(c, userID)
+	if !ok {
+		return
+	}
+	af := attemptForm{}
+	if err := c.ShouldBindJSON(&af); err != nil {
+		di.Zap().Errorf("failed to bind form: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+		return
+	}
+	given := make(map[uint][]string, len(af.Answers))
+	for _, a := range af.Answers {
+		answer, err := normalizeAnswer(a.Answer)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("question %d: %s", a.QuestionID, err)})
+			return
+		}
+		given[a.QuestionID] = answer
+	}
+
+	// unanswered questions count as wrong
+	attempt := models.QuizAttempt{
+		QuizID: quiz.ID,
+		UserID: userID,
+		Total:  uint(len(quiz.Questions)),
+	}
+	results := make([]gin.H, 0, len(quiz.Questions))
+	for _, q := range quiz.Questions {
+		correct := gradeQuestion(&q, given[q.ID])
+		if correct {
+			attempt.Score++
+		}
+		attempt.Answers = append(attempt.Answers, models.QuizAnswer{
+			Given:      given[q.ID],
+			Correct:    correct,
+			QuizID:     quiz.ID,
+			QuestionID: q.ID,
+		})
+		results = append(results, gin.H{
+			"questionID":  q.ID,
+			"given":       given[q.ID],
+			"correct":     correct,
+			"answer":      q.Answer,
+			"explanation": q.Explanation,
+		})
+	}
+	if res := di.Gorm().Create(&attempt); res.Error != nil {
+		di.Zap().Errorf("failed to save attempt of quiz %d: %s", quiz.ID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "OK",
+		"data": gin.H{
+			"attemptID": attempt.ID,
+			"score":     attempt.Score,
+			"total":     attempt.Total,
+			"results":   results,
+		},
+	})
+}
+
+func (t *QuizController) GetAttempts(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	quiz, _, ok := loadQuiz(c, userID)
+	if !ok {
+		return
+	}
+
+	var attempts []models.QuizAttempt
+	res := di.Gorm().Preload("Answers").Where("quiz_id = ? AND user_id = ?", quiz.ID, userID).
+		Order("created_at DESC").Find(&attempts)
+	if res.Error != nil {
+		di.Zap().Errorf("failed to query attempts of quiz %d: %s", quiz.ID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    attempts,
+	})
+}
+
+func (t *QuizController) GetStats(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	quiz, textbook, ok := loadQuiz(c, userID)
+	if !ok {
+		return
+	}
+	if textbook.AuthorID != userID && quiz.AuthorID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return
+	}
+
+	var answers []models.QuizAnswer
+	if res := di.Gorm().Select("question_id", "given", "correct").Where("quiz_id = ?", quiz.ID).Find(&answers); res.Error != nil {
+		di.Zap().Errorf("failed to query answers of quiz %d: %s", quiz.ID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	var attempts int64
+	di.Gorm().Model(&models.QuizAttempt{}).Where("quiz_id = ?", quiz.ID).Count(&attempts)
+
+	type questionStats struct {
+		answered, correct int
+		choices           map[string]int
+	}
+	stats := make(map[uint]*questionStats, len(quiz.Questions))
+	for _, q := range quiz.Questions {
+		stats[q.ID] = &questionStats{choices: map[string]int{}}
+	}
+	for _, a := range answers {
+		s, ok := stats[a.QuestionID]
+		if !ok {
+			continue
+		}
+		s.answered++
+		if a.Correct {
+			s.correct++
+		}
+		for _, g := range a.Given {
+			s.choices[g]++
+		}
+	}
+
+	data := make([]gin.H, 0, len(quiz.Questions))
+	for _, q := range quiz.Questions {
+		s := stats[q.ID]
+		rate := 0.0
+		if s.answered > 0 {
+			rate = float64(s.correct) / float64(s.answered)
+		}
+		item := gin.H{
+			"questionID":  q.ID,
+			"prompt":      q.Prompt,
+			"answered":    s.answered,
+			"correct":     s.correct,
+			"correctRate": rate,
+		}
+		if q.Kind != models.QuestionFill {
+			item["choices"] = s.choices
+		}
+		data = append(data, item)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data": gin.H{
+			"attempts":  attempts,
+			"questions": data,
+		},
+	})
+}
+
+// loadQuizVersion loads the textbook and version of the route, readable by the user
+func loadQuizVersion(c *gin.Context, userID uint) (models.Textbook, models.TextbookVersion, bool) {
+	var textbook models.Textbook
+	var version models.TextbookVersion
+	tid, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid textbook id"})
+		return textbook, version, false
+	}
+	if !loadReadableTextbook(c, uint(tid), userID, &textbook) {
+		return textbook, version, false
+	}
+	res := di.Gorm().Select("id", "no").Where("id = ? AND textbook_id = ?", c.Param("vid"), tid).First(&version)
+	if res.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("version %s not found", c.Param("vid"))})
+		return textbook, version, false
+	}
+	return textbook, version, true
+}
+
+// loadQuiz loads the quiz of the route with ordered questions and its textbook
+func loadQuiz(c *gin.Context, userID uint) (models.Quiz, models.Textbook, bool) {
+	var quiz models.Quiz
+	var textbook models.Textbook
+	qid, err := strconv.ParseUint(c.Param("qid"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid quiz id"})
+		return quiz, textbook, false
+	}
+	res := di.Gorm().Preload("TextbookVersion", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "textbook_id")
+	}).Preload("Questions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).First(&quiz, qid)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("quiz %d not found", qid)})
+		} else {
+			di.Zap().Errorf("failed to query quiz: %s", res.Error)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		}
+		return quiz, textbook, false
+	}
+	if !loadReadableTextbook(c, quiz.TextbookVersion.TextbookID, userID, &textbook) {
+		return quiz, textbook, false
+	}
+	return quiz, textbook, true
+}
+
+// loadReadableTextbook loads a textbook that is public or written by the user
+func loadReadableTextbook(c *gin.Context, tid, userID uint, textbook *models.Textbook) bool {
+	res := di.Gorm().First(textbook, tid)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("textbook %d not found", tid)})
+		} else {
+			di.Zap().Errorf("failed to query textbook: %s", res.Error)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		}
+		return false
+	}
+	if textbook.IsPrivate && textbook.AuthorID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return false
+	}
+	return true
+}
+
+// markdownHeadings lists the text of the ATX headings of content, skipping fenced code
+func markdownHeadings(content string) []string {
+	var headings []string
+	fence := ""
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[:3]
+			continue
+		}
+		level := len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+		if level < 1 || level > 6 || (len(trimmed) > level && trimmed[level] != ' ' && trimmed[level] != '\t') {
+			continue
+		}
+		// closing hashes are not part of the heading
+		text := strings.TrimSpace(trimmed[level:])
+		if stripped := strings.TrimRight(text, "#"); stripped == "" || strings.HasSuffix(stripped, " ") {
+			text = strings.TrimSpace(stripped)
+		}
+		if text != "" {
+			headings = append(headings, text)
+		}
+	}
+	return headings
+}
+
+// normalizeAnswer turns a json answer like 1, true, "go" or [0, 2] into a list of strings
+func normalizeAnswer(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	items, ok := v.([]any)
+	if !ok {
+		items = []any{v}
+	}
+	answer := make([]string, 0, len(items))
+	for _, item := range items {
+		switch x := item.(type) {
+		case nil:
+		case string:
+			answer = append(answer, x)
+		case float64:
+			answer = append(answer, strconv.FormatFloat(x, 'f', -1, 64))
+		case bool:
+			answer = append(answer, strconv.FormatBool(x))
+		default:
+			return nil, errors.New("answer must be a value or a list of values")
+		}
+	}
+	return answer, nil
+}
+
+// validateQuestion checks the answer of a question fits its kind
+func validateQuestion(q *models.QuizQuestion) error {
+	switch q.Kind {
+	case models.QuestionSingle, models.QuestionMulti:
+		if len(q.Options) < 2 {
+			return errors.New("choice questions need at least two options")
+		}
+		if len(q.Answer) == 0 || (q.Kind == models.QuestionSingle && len(q.Answer) != 1) {
+			return errors.New("wrong number of correct options")
+		}
+		seen := map[string]bool{}
+		for _, a := range q.Answer {
+			i, err := strconv.Atoi(a)
+			if err != nil || i < 0 || i >= len(q.Options) || seen[a] {
+				return fmt.Errorf("invalid option %q", a)
+			}
+			seen[a] = true
+		}
+	case models.QuestionTrueFalse:
+		if len(q.Answer) != 1 || (q.Answer[0] != "true" && q.Answer[0] != "false") {
+			return errors.New("answer must be true or false")
+		}
+		q.Options = nil
+	case models.QuestionFill:
+		if len(q.Answer) == 0 {
+			return errors.New("fill-in questions need at least one accepted answer")
+		}
+		q.Options = nil
+	default:
+		return fmt.Errorf("unknown question kind %q", q.Kind)
+	}
+	return nil
+}
+
+// gradeQuestion compares the given answer with the correct one,
+// fill-in answers ignore case and surrounding spaces
+func gradeQuestion(q *models.QuizQuestion, given []string) bool {
+	switch q.Kind {
+	case models.QuestionFill:
+		if len(given) != 1 {
+			return false
+		}
+		for _, accepted := range q.Answer {
+			if strings.EqualFold(strings.TrimSpace(given[0]), strings.TrimSpace(accepted)) {
+				return true
+			}
+		}
+		return false
+	default:
+		if len(given) != len(q.Answer) {
+			return false
+		}
+		a := append([]string(nil), q.Answer...)
+		g := append([]string(nil), given...)
+		sort.Strings(a)
+		sort.Strings(g)
+		for i := range a {
+			if a[i] != g[i] {
+				return false
+			}
+		}
+		return true
+	}
+}
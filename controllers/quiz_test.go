@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"encoding/json"
+	"hammer-web-api/models"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeAnswer(t *testing.T) {
+	cases := []struct {
+		raw     string
+		want    []string
+		wantErr bool
+	}{
+		{raw: `1`, want: []string{"1"}},
+		{raw: `[0, 2]`, want: []string{"0", "2"}},
+		{raw: `true`, want: []string{"true"}},
+		{raw: `"Go"`, want: []string{"Go"}},
+		{raw: `["go", "golang"]`, want: []string{"go", "golang"}},
+		{raw: `1.5`, want: []string{"1.5"}},
+		{raw: `null`, want: []string{}},
+		{raw: `{"a": 1}`, wantErr: true},
+		{raw: `[[1]]`, wantErr: true},
+		{raw: `[1,`, wantErr: true},
+	}
+	for _, tc := range cases {
+		got, err := normalizeAnswer(json.RawMessage(tc.raw))
+		if (err != nil) != tc.wantErr {
+			t.Errorf("normalizeAnswer(%s) error = %v", tc.raw, err)
+			continue
+		}
+		if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("normalizeAnswer(%s) = %q, want %q", tc.raw, got, tc.want)
+		}
+	}
+	if got, err := normalizeAnswer(nil); got != nil || err != nil {
+		t.Errorf("normalizeAnswer(nil) = %q, %v", got, err)
+	}
+}
+
+func TestValidateQuestion(t *testing.T) {
+	options := []string{"a", "b", "c"}
+	cases := []struct {
+		name  string
+		q     models.QuizQuestion
+		valid bool
+	}{
+		{"single", models.QuizQuestion{Kind: models.QuestionSingle, Options: options, Answer: []string{"1"}}, true},
+		{"single with two answers", models.QuizQuestion{Kind: models.QuestionSingle, Options: options, Answer: []string{"0", "1"}}, false},
+		{"single out of range", models.QuizQuestion{Kind: models.QuestionSingle, Options: options, Answer: []string{"3"}}, false},
+		{"single not an index", models.QuizQuestion{Kind: models.QuestionSingle, Options: options, Answer: []string{"a"}}, false},
+		{"one option", models.QuizQuestion{Kind: models.QuestionSingle, Options: options[:1], Answer: []string{"0"}}, false},
+		{"multi", models.QuizQuestion{Kind: models.QuestionMulti, Options: options, Answer: []string{"0", "2"}}, true},
+		{"multi repeated", models.QuizQuestion{Kind: models.QuestionMulti, Options: options, Answer: []string{"0", "0"}}, false},
+		{"multi without answer", models.QuizQuestion{Kind: models.QuestionMulti, Options: options}, false},
+		{"true/false", models.QuizQuestion{Kind: models.QuestionTrueFalse, Answer: []string{"false"}}, true},
+		{"true/false other", models.QuizQuestion{Kind: models.QuestionTrueFalse, Answer: []string{"yes"}}, false},
+		{"fill", models.QuizQuestion{Kind: models.QuestionFill, Options: options, Answer: []string{"go"}}, true},
+		{"fill without answer", models.QuizQuestion{Kind: models.QuestionFill}, false},
+		{"unknown kind", models.QuizQuestion{Kind: "essay", Answer: []string{"x"}}, false},
+	}
+	for _, tc := range cases {
+		q := tc.q
+		if err := validateQuestion(&q); (err == nil) != tc.valid {
+			t.Errorf("%s: validateQuestion = %v", tc.name, err)
+		}
+		if tc.valid && (q.Kind == models.QuestionFill || q.Kind == models.QuestionTrueFalse) && q.Options != nil {
+			t.Errorf("%s: options were kept", tc.name)
+		}
+	}
+}
+
+func TestGradeQuestion(t *testing.T) {
+	multi := &models.QuizQuestion{Kind: models.QuestionMulti, Answer: []string{"0", "2"}}
+	fill := &models.QuizQuestion{Kind: models.QuestionFill, Answer: []string{"Go", "golang"}}
+	cases := []struct {
+		q     *models.QuizQuestion
+		given []string
+		want  bool
+	}{
+		{multi, []string{"2", "0"}, true},
+		{multi, []string{"0"}, false},
+		{multi, []string{"0", "1"}, false},
+		{multi, []string{"0", "2", "1"}, false},
+		{fill, []string{"  go "}, true},
+		{fill, []string{"GOLANG"}, true},
+		{fill, []string{"rust"}, false},
+		{fill, []string{"go", "golang"}, false},
+		{fill, nil, false},
+	}
+	for _, tc := range cases {
+		if got := gradeQuestion(tc.q, tc.given); got != tc.want {
+			t.Errorf("gradeQuestion(%s %q, %q) = %v, want %v", tc.q.Kind, tc.q.Answer, tc.given, got, tc.want)
+		}
+	}
+	// grading must not reorder the stored answer
+	if !reflect.DeepEqual(multi.Answer, models.StringList{"0", "2"}) {
+		t.Errorf("answer was modified: %q", multi.Answer)
+	}
+}
+
+func TestMarkdownHeadings(t *testing.T) {
+	content := "# Go 入门\n\nintro\n\n## Variables ##\n```go\n# not a heading\n```\n###No space\n####### too deep\n  ### Loops\n## C#\n"
+	want := []string{"Go 入门", "Variables", "Loops", "C#"}
+	if got := markdownHeadings(content); !reflect.DeepEqual(got, want) {
+		t.Errorf("markdownHeadings = %q, want %q", got, want)
+	}
+}
@@ -2,10 +2,10 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
 	"gorm.io/gorm"
 	"hammer-web-api/di"
 	"hammer-web-api/models"
@@ -20,17 +20,10 @@ type TextbookController struct {
 
 func (t *TextbookController) GetSubscription(c *gin.Context) {
 	// filter condition for user
-	claim, exists := c.Get("payload")
-	if !exists {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "failed to get jwt payload"})
-		return
-	}
-
-	payload, ok := claim.(jwt.MapClaims)
+	userID, ok := currentUserID(c)
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "failed to parse claim"})
+		return
 	}
-	userID := payload["uid"]
 
 	// query table UserOperation
 	var subscriptions []models.UserOperation
@@ -49,8 +42,8 @@ func (t *TextbookController) GetSubscription(c *gin.Context) {
 
 func (t *TextbookController) GetUserWorkList(c *gin.Context) {
 	// filter condition for user
-	userID := parseUserIDFromToken(c)
-	if userID == "" {
+	userID, ok := currentUserID(c)
+	if !ok {
 		return
 	}
 	// query table Textbook
@@ -75,16 +68,147 @@ func (t *TextbookController) GetUserWorkContent(c *gin.Context) {
 	tid, err := strconv.ParseUint(textbookID, 10, 0)
 	if err != nil {
 		di.Zap().Errorf("failed to convert string-type textbook id to uint-type: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid textbook id"})
+		return
 	}
 	// Get user id from token
-	userID := parseUserIDFromToken(c)
-	if userID == "" {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	// The whole payload is cached, a hit needs no database query at all
+	payload, err := textbookCache.Get(c.Request.Context(), uint(tid), t.TextbookExpireDuration, func() (*textbookPayload, error) {
+		return loadTextbookPayload(uint(tid))
+	})
+	if errors.Is(err, errTextbookNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("textbook %d not found", tid)})
+		return
+	}
+	if err != nil {
+		di.Zap().Errorf("failed to load textbook %d: %s", tid, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	// Only the author and the collaborator, who needs the ETag to edit, may read it
+	if payload.AuthorID != userID && (payload.CollaboratorID == nil || *payload.CollaboratorID != userID) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return
+	}
+
+	respData := gin.H{
+		"latestTextbook": gin.H{
+			"content": payload.Content,
+			"version": payload.Version,
+		},
+		"allVersions": payload.Versions,
+		"forkCount":   payload.ForkCount,
+	}
+	if payload.ForkedFrom != nil {
+		respData["forkedFrom"] = payload.ForkedFrom
+	}
+	body, err := json.Marshal(gin.H{
+		"message": "OK",
+		"data":    respData,
+	})
+	if err != nil {
+		di.Zap().Errorf("failed to encode textbook %d: %s", tid, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
 		return
 	}
 
-	// Confirm that it is the resource users self have requested but not other users
+	// the fork metadata changes without a new version but bumps the textbook
+	lastModified := payload.CreatedAt
+	if payload.UpdatedAt.After(lastModified) {
+		lastModified = payload.UpdatedAt
+	}
+	c.Header("Cache-Control", "private, no-cache")
+	if checkNotModified(c, representationETag(payload.VersionID, body), lastModified) {
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// loadTextbookPayload reads everything GetUserWorkContent needs from the database
+func loadTextbookPayload(tid uint) (*textbookPayload, error) {
 	var textbook models.Textbook
-	res := di.Gorm().Select("id").Where("id = ? AND author_id = ?", tid, userID).First(&textbook)
+	res := di.Gorm().Select("id", "author_id", "collaborator_id", "forked_from_id", "fork_count", "updated_at").First(&textbook, tid)
+	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return nil, errTextbookNotFound
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	var latestVersion models.TextbookVersion
+	res = di.Gorm().Where("textbook_id = ?", tid).Order("created_at DESC").First(&latestVersion)
+	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return nil, errTextbookNotFound
+	}
+	if res.Error == nil {
+		res.Error = loadVersionContent(di.Gorm(), &latestVersion)
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	// query all versions and version id
+	var versions []models.TextbookVersion
+	if res = di.Gorm().Select("id", "no").Where("textbook_id = ?", tid).Order("created_at").Find(&versions); res.Error != nil {
+		return nil, res.Error
+	}
+
+	payload := &textbookPayload{
+		AuthorID:       textbook.AuthorID,
+		CollaboratorID: textbook.CollaboratorID,
+		VersionID:      latestVersion.ID,
+		Version:        latestVersion.No,
+		CreatedAt:      latestVersion.CreatedAt,
+		UpdatedAt:      textbook.UpdatedAt,
+		Content:        latestVersion.Content,
+		Versions:       make([]versionBrief, 0, len(versions)),
+		ForkCount:      textbook.ForkCount,
+	}
+	for _, v := range versions {
+		payload.Versions = append(payload.Versions, versionBrief{ID: v.ID, No: v.No})
+	}
+
+	// forks keep the attribution of their original even if it was deleted since
+	if textbook.ForkedFromID != nil {
+		var source models.Textbook
+		if di.Gorm().Unscoped().Preload("Author").First(&source, *textbook.ForkedFromID).RowsAffected > 0 {
+			payload.ForkedFrom = forkAttribution(&source)
+		}
+	}
+	return payload, nil
+}
+
+func (t *TextbookController) GetCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    textbookCache.Stats(),
+	})
+}
+
+func (t *TextbookController) GetVersion(c *gin.Context) {
+	tid, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid textbook id"})
+		return
+	}
+	vid, err := strconv.ParseUint(c.Param("vid"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid version id"})
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var textbook models.Textbook
+	res := di.Gorm().Select("id").Where("id = ? AND (author_id = ? OR collaborator_id = ?)", tid, userID, userID).First(&textbook)
 	if res.Error != nil {
 		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
@@ -95,91 +219,289 @@ func (t *TextbookController) GetUserWorkContent(c *gin.Context) {
 		return
 	}
 
-	// Query Version Table for textbook content but query cache first
-	var latestVersion models.TextbookVersion
-
-	content, err := di.GoRedis().Get(context.Background(),
-		fmt.Sprintf("id_%d_latest_content", tid),
-	).Result()
-	if err != nil {
-		res = di.Gorm().Where("textbook_id = ?", tid).Order("created_at DESC").First(&latestVersion)
-		_, redisErr := di.GoRedis().SetEx(context.Background(),
-			fmt.Sprintf("id_%d_latest_content", tid),
-			latestVersion.Content,
-			t.TextbookExpireDuration).Result()
-		if redisErr != nil {
-			di.Zap().Errorf("failed to setex %d_latest: %s", tid, redisErr)
-		}
-	} else {
-		res = di.Gorm().Select("no").Where("textbook_id = ?", tid).Order("created_at DESC").First(&latestVersion)
-		latestVersion.Content = content
-		// TODO: Is it necessary to extend expiration time ?
-	}
+	var version models.TextbookVersion
+	res = di.Gorm().Select("id", "no", "created_at").Where("id = ? AND textbook_id = ?", vid, tid).First(&version)
 	if res.RowsAffected == 0 {
-		di.Zap().Errorf("failed to get latest version textbook while tid is %d: %s", tid, res.Error)
-		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("textbook %d not found", tid)})
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("version %d not found", vid)})
 		return
 	}
 
-	// query all versions and version id
-	var versions []models.TextbookVersion
-	res = di.Gorm().Select("no").Where("textbook_id = ?", tid).Find(&versions)
-	if res.RowsAffected == 0 {
-		di.Zap().Errorf("failed to get any version of textbook while tid is %d: %s", tid, res.Error)
-		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("textbook %d not found", tid)})
+	// a version never changes once published
+	c.Header("Cache-Control", "private, max-age=31536000, immutable")
+	if checkNotModified(c, versionETag(version.ID), version.CreatedAt) {
 		return
 	}
-
-	// Encapsulate data and response
-	allVersionsData := make([]map[string]any, 0)
-	for _, v := range versions {
-		tempMap := make(map[string]any)
-		tempMap["version"] = v.No
-		tempMap["vid"] = v.ID
-		allVersionsData = append(allVersionsData, tempMap)
+	if res = di.Gorm().Select("content", "blob_hash").First(&version, version.ID); res.Error == nil {
+		res.Error = loadVersionContent(di.Gorm(), &version)
 	}
-
-	respData := gin.H{
-		"latestTextbook": gin.H{
-			"content": latestVersion.Content,
-			"version": latestVersion.No,
-		},
-		"allVersions": allVersionsData,
+	if res.Error != nil {
+		di.Zap().Errorf("failed to get content of version %d: %s", version.ID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "OK",
-		"data":    respData,
+		"data": gin.H{
+			"vid":       version.ID,
+			"version":   version.No,
+			"content":   version.Content,
+			"createdAt": version.CreatedAt,
+		},
 	})
+}
 
+type textbookForm struct {
+	Title     string `json:"title" binding:"required,max=100"`
+	Tag       string `json:"tag" binding:"required,max=50"`
+	Desc      string `json:"desc" binding:"max=255"`
+	IsPrivate bool   `json:"isPrivate"`
+	No        string `json:"no"`
+	Content   string `json:"content" binding:"required,max=1000000"`
 }
 
 func (t *TextbookController) Post(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	tf := textbookForm{}
+	if err := c.ShouldBindJSON(&tf); err != nil {
+		di.Zap().Errorf("failed to bind form: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+		return
+	}
+	if di.Gorm().Where("author_id = ? AND title = ?", userID, tf.Title).First(&models.Textbook{}).RowsAffected > 0 {
+		c.JSON(http.StatusConflict, gin.H{"message": "you already have a textbook with this title"})
+		return
+	}
+
+	textbook := models.Textbook{
+		Title:     tf.Title,
+		Tag:       tf.Tag,
+		Desc:      tf.Desc,
+		IsPrivate: tf.IsPrivate,
+		AuthorID:  userID,
+	}
+	version := models.TextbookVersion{No: tf.No, Content: tf.Content}
+	if version.No == "" {
+		version.No = "1.0.0"
+	}
+	err := di.Gorm().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&textbook).Error; err != nil {
+			return err
+		}
+		version.TextbookID = textbook.ID
+		return saveVersion(tx, &version)
+	})
+	if errors.Is(err, models.ErrVersionFormat) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid version format"})
+		return
+	}
+	if err != nil {
+		di.Zap().Errorf("failed to create textbook: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	// the id may have been probed before, drop the cached 404
+	textbookCache.Invalidate(context.Background(), textbook.ID)
+	emitWebhookEvent(models.WebhookTextbookCreated, textbook, gin.H{"vid": version.ID, "version": version.No})
+	recordTextbookActivity(textbook, nil)
 
+	c.Header("ETag", versionETag(version.ID))
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "OK",
+		"data": gin.H{
+			"id":      textbook.ID,
+			"title":   textbook.Title,
+			"vid":     version.ID,
+			"version": version.No,
+		},
+	})
+}
+
+type textbookEditForm struct {
+	No      string `json:"no"`
+	Content string `json:"content" binding:"required,max=1000000"`
 }
 
 func (t *TextbookController) Put(c *gin.Context) {
+	tid, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid textbook id"})
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
 
-}
+	// only the author and the collaborator can edit
+	var textbook models.Textbook
+	res := di.Gorm().Where("id = ? AND (author_id = ? OR collaborator_id = ?)", tid, userID, userID).First(&textbook)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		} else {
+			di.Zap().Errorf("failed to query textbook: %s", res.Error)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		}
+		return
+	}
 
-func (t *TextbookController) Delete(c *gin.Context) {
+	// edits must be based on a known version
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"message": "If-Match header is required"})
+		return
+	}
+	ef := textbookEditForm{}
+	if err = c.ShouldBindJSON(&ef); err != nil {
+		di.Zap().Errorf("failed to bind form: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+		return
+	}
 
+	var latest models.TextbookVersion
+	if res = di.Gorm().Where("textbook_id = ?", tid).Order("created_at DESC").First(&latest); res.Error != nil {
+		di.Zap().Errorf("failed to get latest version textbook while tid is %d: %s", tid, res.Error)
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("textbook %d not found", tid)})
+		return
+	}
+
+	content := ef.Content
+	merged := false
+	vids, wildcard := parseVersionETag(ifMatch)
+	if !wildcard && !containsUint(vids, latest.ID) {
+		// somebody saved in between, try to merge both edits onto the latest version
+		var base models.TextbookVersion
+		if len(vids) == 0 || di.Gorm().Where("id = ? AND textbook_id = ?", vids[0], tid).First(&base).RowsAffected == 0 {
+			c.Header("ETag", versionETag(latest.ID))
+			c.JSON(http.StatusPreconditionFailed, gin.H{"message": "textbook has been modified"})
+			return
+		}
+		if err = loadVersionContent(di.Gorm(), &base); err == nil {
+			err = loadVersionContent(di.Gorm(), &latest)
+		}
+		if err != nil {
+			di.Zap().Errorf("failed to get content of textbook %d: %s", tid, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+			return
+		}
+		mergedContent, clean := merge3(base.Content, latest.Content, ef.Content)
+		if !clean || c.Query("merge") != "auto" {
+			c.Header("ETag", versionETag(latest.ID))
+			data := gin.H{
+				"latestVersion": latest.No,
+				"etag":          versionETag(latest.ID),
+				"mergeable":     clean,
+				"diff":          unifiedDiff(base.Content, latest.Content, 3),
+			}
+			if clean {
+				data["mergedContent"] = mergedContent
+			}
+			c.JSON(http.StatusPreconditionFailed, gin.H{
+				"message": "textbook has been modified",
+				"data":    data,
+			})
+			return
+		}
+		content = mergedContent
+		merged = true
+	}
+
+	version := models.TextbookVersion{
+		No:         ef.No,
+		Content:    content,
+		TextbookID: textbook.ID,
+	}
+	if version.No == "" {
+		version.No = nextVersionNo(latest.No)
+	}
+	// the latest version may only be replaced by the one it was checked against
+	err = di.Gorm().Transaction(func(tx *gorm.DB) error {
+		var current models.TextbookVersion
+		if err := latestVersionLocked(tx, uint(tid), &current); err != nil {
+			return err
+		}
+		if current.ID != latest.ID {
+			return errVersionConflict
+		}
+		return saveVersion(tx, &version)
+	})
+	if errors.Is(err, errVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"message": "textbook has been modified"})
+		return
+	}
+	if errors.Is(err, models.ErrVersionFormat) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid version format"})
+		return
+	}
+	if err != nil {
+		di.Zap().Errorf("failed to create version of textbook %d: %s", tid, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	textbookCache.Invalidate(context.Background(), uint(tid))
+	notifyNewVersion(textbook.ID, version.ID, userID, version.No)
+	emitWebhookEvent(models.WebhookVersionPublished, textbook, gin.H{"vid": version.ID, "version": version.No, "merged": merged})
+	publishCollaboratorActivity(textbook, userID, "version.published", gin.H{"vid": version.ID, "version": version.No})
+	recordTextbookActivity(textbook, &version)
+
+	c.Header("ETag", versionETag(version.ID))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data": gin.H{
+			"vid":     version.ID,
+			"version": version.No,
+			"merged":  merged,
+		},
+	})
 }
 
-func parseUserIDFromToken(c *gin.Context) any {
-	claim, exists := c.Get("payload")
-	if !exists {
-		di.Zap().Error("payload does not exist")
-		c.JSON(http.StatusBadRequest, gin.H{"message": "failed to get jwt payload"})
-		return ""
+func (t *TextbookController) Delete(c *gin.Context) {
+	tid, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid textbook id"})
+		return
 	}
-	payload, ok := claim.(jwt.MapClaims)
+	userID, ok := currentUserID(c)
 	if !ok {
-		di.Zap().Error("payload does not exist")
-		c.JSON(http.StatusBadRequest, gin.H{"message": "failed to parse claim"})
-		return ""
+		return
 	}
-	uid := payload["uid"]
 
-	return uid
+	// only the author can delete, versions are kept for forks and proposals
+	var textbook models.Textbook
+	if di.Gorm().Where("id = ? AND author_id = ?", tid, userID).First(&textbook).RowsAffected == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return
+	}
+	if res := di.Gorm().Delete(&textbook); res.Error != nil {
+		di.Zap().Errorf("failed to delete textbook %d: %s", tid, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	textbookCache.Invalidate(context.Background(), textbook.ID)
+	emitWebhookEvent(models.WebhookTextbookDeleted, textbook, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+	})
+}
+
+var (
+	errVersionConflict = errors.New("latest version changed")
+	errNoPermission    = errors.New("request no permission")
+)
+
+func containsUint(s []uint, v uint) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hammer-web-api/di"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// textbookNegativeTTL is how long a missing textbook is remembered
+	textbookNegativeTTL = 30 * time.Second
+	// textbookGenerationTTL outlives every cached payload
+	textbookGenerationTTL = 24 * time.Hour
+)
+
+var errTextbookNotFound = errors.New("textbook not found")
+
+// textbookPayload is everything GetUserWorkContent responds with, cached per textbook
+type textbookPayload struct {
+	Missing bool `json:"missing,omitempty"`
+
+	AuthorID       uint           `json:"authorID"`
+	CollaboratorID *uint          `json:"collaboratorID,omitempty"`
+	VersionID      uint           `json:"versionID"`
+	Version        string         `json:"version"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	UpdatedAt      time.Time      `json:"updatedAt"`
+	Content        string         `json:"content"`
+	Versions       []versionBrief `json:"versions"`
+	ForkCount      uint           `json:"forkCount"`
+	ForkedFrom     map[string]any `json:"forkedFrom,omitempty"`
+}
+
+type versionBrief struct {
+	ID uint   `json:"vid"`
+	No string `json:"version"`
+}
+
+// textbookContentCache caches textbook payloads in di.Cache, concurrent misses of one textbook
+// share a single load and missing textbooks are cached for textbookNegativeTTL
+type textbookContentCache struct {
+	flight flightGroup
+
+	hits         atomic.Int64
+	negativeHits atomic.Int64
+	misses       atomic.Int64
+	sharedLoads  atomic.Int64
+	errors       atomic.Int64
+}
+
+var textbookCache = &textbookContentCache{}
+
+func textbookCacheKey(tid uint) string {
+	return fmt.Sprintf("textbook:%d:payload", tid)
+}
+
+// textbookGenerationKey counts the invalidations of a textbook, a payload is only cached
+// if no invalidation happened while it was loaded
+func textbookGenerationKey(tid uint) string {
+	return fmt.Sprintf("textbook:%d:gen", tid)
+}
+
+// Get returns the payload of textbook tid, loading and caching it for ttl on a miss
+func (t *textbookContentCache) Get(ctx context.Context, tid uint, ttl time.Duration, load func() (*textbookPayload, error)) (*textbookPayload, error) {
+	key := textbookCacheKey(tid)
+	if p, ok := t.lookup(ctx, key); ok {
+		if p.Missing {
+			t.negativeHits.Add(1)
+			return nil, errTextbookNotFound
+		}
+		t.hits.Add(1)
+		return p, nil
+	}
+	t.misses.Add(1)
+
+	v, err, shared := t.flight.Do(key, func() (any, error) {
+		// the result is shared, so it must not depend on the context of the first caller
+		genKey := textbookGenerationKey(tid)
+		gen, genErr := di.Cache().Counter(context.Background(), genKey)
+		if genErr != nil {
+			t.errors.Add(1)
+			di.Zap().Errorf("failed to get %s: %s", genKey, genErr)
+		}
+		p, err := load()
+		if errors.Is(err, errTextbookNotFound) {
+			if genErr == nil {
+				t.store(context.Background(), key, &textbookPayload{Missing: true}, textbookNegativeTTL, genKey, gen)
+			}
+			return nil, err
+		}
+		if err != nil {
+			// failures are never cached
+			return nil, err
+		}
+		if genErr == nil {
+			t.store(context.Background(), key, p, ttl, genKey, gen)
+		}
+		return p, nil
+	})
+	if shared {
+		t.sharedLoads.Add(1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p, ok := v.(*textbookPayload)
+	if !ok {
+		return nil, fmt.Errorf("failed to load textbook %d", tid)
+	}
+	return p, nil
+}
+
+// Invalidate drops the cached payload of textbook tid and keeps loads already running from
+// caching what they read, call it whenever the textbook is created or a version is published
+func (t *textbookContentCache) Invalidate(ctx context.Context, tid uint) {
+	if err := di.Cache().Bump(ctx, textbookGenerationKey(tid), textbookGenerationTTL); err != nil {
+		t.errors.Add(1)
+		di.Zap().Errorf("failed to incr %s: %s", textbookGenerationKey(tid), err)
+	}
+	if err := di.Cache().Delete(ctx, textbookCacheKey(tid)); err != nil {
+		t.errors.Add(1)
+		di.Zap().Errorf("failed to del %s: %s", textbookCacheKey(tid), err)
+	}
+}
+
+func (t *textbookContentCache) Stats() map[string]any {
+	hits, negativeHits, misses := t.hits.Load(), t.negativeHits.Load(), t.misses.Load()
+	ratio := 0.0
+	if total := hits + negativeHits + misses; total > 0 {
+		ratio = float64(hits+negativeHits) / float64(total)
+	}
+	return map[string]any{
+		"hits":         hits,
+		"negativeHits": negativeHits,
+		"misses":       misses,
+		"sharedLoads":  t.sharedLoads.Load(),
+		"errors":       t.errors.Load(),
+		"hitRatio":     ratio,
+		"tiers":        di.Cache().Stats(),
+	}
+}
+
+func (t *textbookContentCache) lookup(ctx context.Context, key string) (*textbookPayload, bool) {
+	b, err := di.Cache().Get(ctx, key)
+	if err != nil {
+		if !errors.Is(err, di.ErrCacheMiss) {
+			t.errors.Add(1)
+			di.Zap().Errorf("failed to get %s: %s", key, err)
+		}
+		return nil, false
+	}
+	p := &textbookPayload{}
+	if err = json.Unmarshal(b, p); err != nil {
+		t.errors.Add(1)
+		di.Zap().Errorf("failed to decode %s: %s", key, err)
+		return nil, false
+	}
+	return p, true
+}
+
+// store caches p unless the generation of the textbook moved on from gen while p was loaded
+func (t *textbookContentCache) store(ctx context.Context, key string, p *textbookPayload, ttl time.Duration, genKey string, gen int64) {
+	b, err := json.Marshal(p)
+	if err == nil {
+		_, err = di.Cache().SetIf(ctx, key, b, ttl, genKey, gen)
+	}
+	if err != nil {
+		t.errors.Add(1)
+		di.Zap().Errorf("failed to setex %s: %s", key, err)
+	}
+}
+
+// flightGroup runs only one call per key at a time, later callers wait for its result
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg  sync.WaitGroup
+	val any
+	err error
+}
+
+func (g *flightGroup) Do(key string, fn func() (any, error)) (v any, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		call.wg.Wait()
+		return call.val, call.err, true
+	}
+	call := &flightCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		call.wg.Done()
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+	}()
+	call.val, call.err = fn()
+	return call.val, call.err, false
+}
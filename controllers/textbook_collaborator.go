@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"hammer-web-api/di"
+	"hammer-web-api/models"
+	"net/http"
+	"strconv"
+)
+
+type collaboratorForm struct {
+	UserID uint `json:"userID" binding:"required"`
+}
+
+func (t *TextbookController) PutCollaborator(c *gin.Context) {
+	tid, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid textbook id"})
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	cf := collaboratorForm{}
+	if err = c.ShouldBindJSON(&cf); err != nil {
+		di.Zap().Errorf("failed to bind form: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+		return
+	}
+
+	var textbook models.Textbook
+	res := di.Gorm().Select("id", "title", "author_id", "collaborator_id").First(&textbook, tid)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("textbook %d not found", tid)})
+		} else {
+			di.Zap().Errorf("failed to query textbook: %s", res.Error)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		}
+		return
+	}
+	if textbook.AuthorID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return
+	}
+	if cf.UserID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "the author cannot be the collaborator"})
+		return
+	}
+	if di.Gorm().Select("id").First(&models.User{}, cf.UserID).RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("user %d not found", cf.UserID)})
+		return
+	}
+
+	if textbook.CollaboratorID == nil || *textbook.CollaboratorID != cf.UserID {
+		previous := textbook.CollaboratorID
+		if res = di.Gorm().Model(&textbook).Update("collaborator_id", cf.UserID); res.Error != nil {
+			di.Zap().Errorf("failed to set collaborator of textbook %d: %s", tid, res.Error)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+			return
+		}
+		textbookCache.Invalidate(context.Background(), textbook.ID)
+		if previous != nil {
+			// the previous collaborator is dropped from draft sessions on every instance
+			publishDraft(context.Background(), textbook.ID, draftEntry{Type: "revoked", UserID: *previous})
+		}
+		notifyInvitation(textbook, cf.UserID)
+		publishCollaboratorActivity(textbook, userID, "collaborator.invited", gin.H{"collaboratorID": cf.UserID})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    gin.H{"collaboratorID": cf.UserID},
+	})
+}
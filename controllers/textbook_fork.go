@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"hammer-web-api/di"
+	"hammer-web-api/models"
+	"net/http"
+	"strconv"
+)
+
+type forkForm struct {
+	Title string `json:"title" binding:"omitempty,max=100"`
+}
+
+func (t *TextbookController) Fork(c *gin.Context) {
+	tid, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid textbook id"})
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	ff := forkForm{}
+	if c.Request.ContentLength > 0 {
+		if err = c.ShouldBindJSON(&ff); err != nil {
+			di.Zap().Errorf("failed to bind form: %s", err)
+			c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+			return
+		}
+	}
+
+	// only public textbooks of other users can be forked
+	var source models.Textbook
+	if res := di.Gorm().Preload("Author").First(&source, tid); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("textbook %d not found", tid)})
+		} else {
+			di.Zap().Errorf("failed to query textbook: %s", res.Error)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		}
+		return
+	}
+	if source.IsPrivate {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return
+	}
+	if source.AuthorID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "cannot fork your own textbook"})
+		return
+	}
+
+	var latestVersion models.TextbookVersion
+	res := di.Gorm().Where("textbook_id = ?", source.ID).Order("created_at DESC").First(&latestVersion)
+	if res.Error == nil {
+		res.Error = loadVersionContent(di.Gorm(), &latestVersion)
+	}
+	if res.Error != nil {
+		di.Zap().Errorf("failed to get latest version textbook while tid is %d: %s", tid, res.Error)
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("textbook %d has no version", tid)})
+		return
+	}
+
+	title := ff.Title
+	if title == "" {
+		title = source.Title
+	}
+	if di.Gorm().Where("author_id = ? AND title = ?", userID, title).First(&models.Textbook{}).RowsAffected > 0 {
+		c.JSON(http.StatusConflict, gin.H{"message": "you already have a textbook with this title"})
+		return
+	}
+
+	// copy textbook and its latest version, count the fork on the source
+	fork := models.Textbook{
+		Title:        title,
+		Tag:          source.Tag,
+		Desc:         source.Desc,
+		AuthorID:     userID,
+		ForkedFromID: &source.ID,
+	}
+	err = di.Gorm().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&fork).Error; err != nil {
+			return err
+		}
+		version := models.TextbookVersion{
+			No:         latestVersion.No,
+			Content:    latestVersion.Content,
+			TextbookID: fork.ID,
+		}
+		if err := saveVersion(tx, &version); err != nil {
+			return err
+		}
+		// Update rather than UpdateColumn, updated_at is the Last-Modified of the source
+		return tx.Model(&source).Update("fork_count", gorm.Expr("fork_count + 1")).Error
+	})
+	if err != nil {
+		di.Zap().Errorf("failed to fork textbook %d: %s", tid, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	// the fork count of the source changed, the fork may have been probed while it was missing
+	textbookCache.Invalidate(c.Request.Context(), source.ID)
+	textbookCache.Invalidate(c.Request.Context(), fork.ID)
+	recordTextbookActivity(fork, nil)
+	emitWebhookEvent(models.WebhookTextbookCreated, fork, gin.H{"version": latestVersion.No, "forkedFromID": source.ID})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "OK",
+		"data": gin.H{
+			"id":         fork.ID,
+			"title":      fork.Title,
+			"version":    latestVersion.No,
+			"forkedFrom": forkAttribution(&source),
+		},
+	})
+}
+
+func (t *TextbookController) GetForks(c *gin.Context) {
+	tid, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid textbook id"})
+		return
+	}
+
+	var forks []models.Textbook
+	res := di.Gorm().Select("id", "title", "author_id", "created_at").
+		Where("forked_from_id = ? AND is_private = ?", tid, false).Find(&forks)
+	if res.Error != nil {
+		di.Zap().Errorf("failed to query forks of textbook %d: %s", tid, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	data := make([]gin.H, 0, len(forks))
+	for _, f := range forks {
+		data = append(data, gin.H{
+			"id":        f.ID,
+			"title":     f.Title,
+			"authorID":  f.AuthorID,
+			"createdAt": f.CreatedAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    data,
+	})
+}
+
+// forkAttribution describes the original of a fork, source.Author must be preloaded
+func forkAttribution(source *models.Textbook) gin.H {
+	return gin.H{
+		"id":       source.ID,
+		"title":    source.Title,
+		"authorID": source.AuthorID,
+		"author":   source.Author.Username,
+	}
+}
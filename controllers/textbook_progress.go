@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"hammer-web-api/di"
+	"hammer-web-api/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type progressForm struct {
+	Percent   uint `json:"percent" binding:"max=100"`
+	Completed bool `json:"completed"`
+}
+
+func (t *TextbookController) PutProgress(c *gin.Context) {
+	tid, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid textbook id"})
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	pf := progressForm{}
+	if err = c.ShouldBindJSON(&pf); err != nil {
+		di.Zap().Errorf("failed to bind form: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+		return
+	}
+
+	var textbook models.Textbook
+	res := di.Gorm().Select("id", "author_id", "is_private").First(&textbook, tid)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("textbook %d not found", tid)})
+		} else {
+			di.Zap().Errorf("failed to query textbook: %s", res.Error)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		}
+		return
+	}
+	if textbook.IsPrivate && textbook.AuthorID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return
+	}
+
+	progress := models.ReadingProgress{
+		UserID:     userID,
+		TextbookID: textbook.ID,
+		Percent:    pf.Percent,
+	}
+	if pf.Completed {
+		now := time.Now()
+		progress.Percent = 100
+		progress.CompletedAt = &now
+	}
+	res = di.Gorm().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "textbook_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"percent", "completed_at", "updated_at"}),
+	}).Create(&progress)
+	if res.Error != nil {
+		di.Zap().Errorf("failed to save progress of textbook %d: %s", tid, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	// keep the other devices of the reader in sync
+	publishEvent(userID, EventReadingSync, progress)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    progress,
+	})
+}
+
+// readingProgress returns the progress of the user on the given textbooks keyed by textbook id
+func readingProgress(userID uint, textbookIDs []uint) (map[uint]models.ReadingProgress, error) {
+	progress := make(map[uint]models.ReadingProgress, len(textbookIDs))
+	if len(textbookIDs) == 0 {
+		return progress, nil
+	}
+	var rows []models.ReadingProgress
+	res := di.Gorm().Where("user_id = ? AND textbook_id IN ?", userID, textbookIDs).Find(&rows)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	for _, row := range rows {
+		progress[row.TextbookID] = row
+	}
+	return progress, nil
+}
+
+// isCompleted reports whether a textbook was marked as read or read to the end
+func isCompleted(p models.ReadingProgress) bool {
+	return p.CompletedAt != nil || p.Percent >= 100
+}
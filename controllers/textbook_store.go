@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"hammer-web-api/models"
+	"strings"
+)
+
+// blobSnapshotInterval is the longest delta chain before a full snapshot is stored again
+const blobSnapshotInterval = 10
+
+var errCorruptBlob = errors.New("corrupt textbook blob")
+
+// deltaOp either copies Copy[1] lines of the base starting at line Copy[0] or inserts lines
+type deltaOp struct {
+	Copy   []int    `json:"c,omitempty"`
+	Insert []string `json:"i,omitempty"`
+}
+
+// latestVersionLocked locks the textbook row for the rest of tx and returns its latest version,
+// writers checking the same base version are serialized on the lock
+func latestVersionLocked(tx *gorm.DB, tid uint, latest *models.TextbookVersion) error {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.Textbook{}, tid).Error; err != nil {
+		return err
+	}
+	return tx.Where("textbook_id = ?", tid).Order("created_at DESC").First(latest).Error
+}
+
+// saveVersion creates v with its content stored as a blob,
+// delta encoded against the latest stored version of the same textbook
+func saveVersion(tx *gorm.DB, v *models.TextbookVersion) error {
+	var prev models.TextbookVersion
+	res := tx.Select("blob_hash").
+		Where("textbook_id = ? AND blob_hash IS NOT NULL AND blob_hash <> ''", v.TextbookID).
+		Order("created_at DESC").Limit(1).Find(&prev)
+	if res.Error != nil {
+		return res.Error
+	}
+
+	hash, _, err := storeBlob(tx, v.Content, prev.BlobHash)
+	if err != nil {
+		return err
+	}
+	v.BlobHash = hash
+	v.Content = ""
+	return tx.Create(v).Error
+}
+
+// loadVersionContent fills v.Content from its blob, v must have blob_hash selected
+func loadVersionContent(db *gorm.DB, v *models.TextbookVersion) error {
+	if v.BlobHash == "" {
+		return nil
+	}
+	content, err := loadBlob(db, v.BlobHash)
+	if err != nil {
+		return err
+	}
+	v.Content = content
+	return nil
+}
+
+// storeBlob stores content unless a blob with the same hash exists and returns its hash
+// along with the number of bytes written
+func storeBlob(tx *gorm.DB, content, baseHash string) (string, int, error) {
+	hash := contentHash(content)
+	var count int64
+	if err := tx.Model(&models.TextbookBlob{}).Where("hash = ?", hash).Count(&count).Error; err != nil {
+		return "", 0, err
+	}
+	if count > 0 {
+		return hash, 0, nil
+	}
+
+	blob := models.TextbookBlob{
+		Hash: hash,
+		Size: uint(len(content)),
+		Data: []byte(content),
+	}
+	// store a delta while the chain is short and the delta actually is smaller
+	if baseHash != "" {
+		var base models.TextbookBlob
+		res := tx.Select("hash", "depth").Where("hash = ?", baseHash).Limit(1).Find(&base)
+		if res.Error != nil {
+			return "", 0, res.Error
+		}
+		if res.RowsAffected > 0 && base.Depth+1 < blobSnapshotInterval {
+			baseContent, err := loadBlob(tx, baseHash)
+			if err != nil {
+				return "", 0, err
+			}
+			delta, err := encodeDelta(baseContent, content)
+			if err != nil {
+				return "", 0, err
+			}
+			if len(delta) < len(content) {
+				blob.BaseHash = &base.Hash
+				blob.Depth = base.Depth + 1
+				blob.Data = delta
+			}
+		}
+	}
+
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&blob).Error; err != nil {
+		return "", 0, err
+	}
+	return hash, len(blob.Data), nil
+}
+
+// loadBlob walks back to the nearest full snapshot and applies the deltas on the way forward
+func loadBlob(db *gorm.DB, hash string) (string, error) {
+	var chain []models.TextbookBlob
+	for h := hash; ; {
+		if len(chain) > blobSnapshotInterval {
+			return "", fmt.Errorf("%w: delta chain of %s is too long", errCorruptBlob, hash)
+		}
+		var blob models.TextbookBlob
+		if err := db.Where("hash = ?", h).First(&blob).Error; err != nil {
+			return "", err
+		}
+		chain = append(chain, blob)
+		if blob.BaseHash == nil {
+			break
+		}
+		h = *blob.BaseHash
+	}
+
+	content := string(chain[len(chain)-1].Data)
+	for i := len(chain) - 2; i >= 0; i-- {
+		var err error
+		if content, err = applyDelta(content, chain[i].Data); err != nil {
+			return "", err
+		}
+	}
+	if contentHash(content) != hash {
+		return "", fmt.Errorf("%w: content of %s does not match its hash", errCorruptBlob, hash)
+	}
+	return content, nil
+}
+
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func encodeDelta(base, content string) ([]byte, error) {
+	var ops []deltaOp
+	pos := 0
+	for _, op := range diffLines(splitLines(base), splitLines(content)) {
+		switch op.Kind {
+		case diffEqual:
+			if n := len(ops); n > 0 && ops[n-1].Copy != nil && ops[n-1].Copy[0]+ops[n-1].Copy[1] == pos {
+				ops[n-1].Copy[1]++
+			} else {
+				ops = append(ops, deltaOp{Copy: []int{pos, 1}})
+			}
+			pos++
+		case diffDelete:
+			pos++
+		case diffInsert:
+			if n := len(ops); n > 0 && ops[n-1].Insert != nil {
+				ops[n-1].Insert = append(ops[n-1].Insert, op.Line)
+			} else {
+				ops = append(ops, deltaOp{Insert: []string{op.Line}})
+			}
+		}
+	}
+	return json.Marshal(ops)
+}
+
+func applyDelta(base string, delta []byte) (string, error) {
+	var ops []deltaOp
+	if err := json.Unmarshal(delta, &ops); err != nil {
+		return "", fmt.Errorf("%w: %s", errCorruptBlob, err)
+	}
+	lines := splitLines(base)
+	var out []string
+	for _, op := range ops {
+		if op.Copy != nil {
+			if len(op.Copy) != 2 || op.Copy[0] < 0 || op.Copy[1] < 0 || op.Copy[0]+op.Copy[1] > len(lines) {
+				return "", fmt.Errorf("%w: copy out of range", errCorruptBlob)
+			}
+			out = append(out, lines[op.Copy[0]:op.Copy[0]+op.Copy[1]]...)
+		}
+		out = append(out, op.Insert...)
+	}
+	return strings.Join(out, "\n"), nil
+}
+
+// MigrateVersionBlobs moves the inline content of every textbook version into blobs and
+// returns the number of converted versions with the content bytes before and after
+func MigrateVersionBlobs(db *gorm.DB) (converted int, before, after int64, err error) {
+	var textbookIDs []uint
+	err = db.Unscoped().Model(&models.TextbookVersion{}).Distinct().Order("textbook_id").Pluck("textbook_id", &textbookIDs).Error
+	if err != nil {
+		return
+	}
+
+	for _, tid := range textbookIDs {
+		var versions []models.TextbookVersion
+		if err = db.Unscoped().Where("textbook_id = ?", tid).Order("created_at").Find(&versions).Error; err != nil {
+			return
+		}
+		prev := ""
+		for _, v := range versions {
+			if v.BlobHash != "" {
+				prev = v.BlobHash
+				continue
+			}
+			var size, stored int
+			err = db.Transaction(func(tx *gorm.DB) error {
+				var err error
+				prev, size, stored, err = migrateVersionBlob(tx, &v, prev)
+				return err
+			})
+			if err != nil {
+				return
+			}
+			before += int64(size)
+			after += int64(stored)
+			converted++
+		}
+	}
+	return
+}
+
+// migrateVersionBlob moves the inline content of v into a blob based on prevHash and returns
+// the new hash with the inline size and the stored size, the update clears v.Content
+func migrateVersionBlob(tx *gorm.DB, v *models.TextbookVersion, prevHash string) (string, int, int, error) {
+	size := len(v.Content)
+	hash, stored, err := storeBlob(tx, v.Content, prevHash)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	err = tx.Unscoped().Model(v).UpdateColumns(map[string]any{"blob_hash": hash, "content": ""}).Error
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return hash, size, stored, nil
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"hammer-web-api/models"
+	"testing"
+)
+
+// dryRunDB builds statements without a server, every query finds nothing
+func dryRunDB(t *testing.T) *gorm.DB {
+	dialector := mysql.New(mysql.Config{DSN: "test@tcp(127.0.0.1:1)/test", SkipInitializeWithVersion: true})
+	db, err := gorm.Open(dialector, &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestMigrateVersionBlobSizes(t *testing.T) {
+	v := &models.TextbookVersion{Content: "# Title\n\nsome content\n"}
+	v.ID = 1
+	hash, size, stored, err := migrateVersionBlob(dryRunDB(t), v, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != contentHash("# Title\n\nsome content\n") {
+		t.Errorf("hash = %s", hash)
+	}
+	// the size is taken before the update empties the content
+	if size != 22 || stored != 22 {
+		t.Errorf("size = %d, stored = %d, want 22 and 22", size, stored)
+	}
+	if v.Content != "" {
+		t.Errorf("content was not cleared: %q", v.Content)
+	}
+}
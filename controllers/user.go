@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
 	dysmsapi "github.com/alibabacloud-go/dysmsapi-20170525/v3/client"
@@ -10,11 +11,15 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/mix-go/xutil/xenv"
 	bc "github.com/mojocn/base64Captcha"
+	"gorm.io/gorm"
 	"hammer-web-api/config"
 	"hammer-web-api/di"
+	"hammer-web-api/middleware"
 	"hammer-web-api/models"
 	"math/rand"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -30,6 +35,28 @@ type registerForm struct {
 	SmsCode  string `json:"smsCode" binding:"required"`
 }
 
+// userUpdateForm holds the profile fields to change, absent fields are kept
+type userUpdateForm struct {
+	Username *string `json:"username" binding:"omitempty,max=255"`
+	Profile  *string `json:"profile" binding:"omitempty,max=5000"`
+	BirthDay *string `json:"birthDay" binding:"omitempty,datetime=2006-01-02"`
+	Avatar   *string `json:"avatar" binding:"omitempty,url,max=255"`
+	Email    *string `json:"email" binding:"omitempty,email,max=255"`
+	Phone    *string `json:"phone" binding:"omitempty,len=11,numeric"`
+}
+
+// profileTextbook is what a profile shows of each textbook
+type profileTextbook struct {
+	ID        uint      `json:"id"`
+	Title     string    `json:"title"`
+	Tag       string    `json:"tag"`
+	Desc      string    `json:"desc"`
+	IsHot     bool      `json:"isHot"`
+	IsPrivate bool      `json:"isPrivate"`
+	ForkCount uint      `json:"forkCount"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
 type loginForm struct {
 	Phone     string `json:"phone" binding:"required"`
 	Password  string `json:"password" binding:"required"`
@@ -63,29 +90,10 @@ func GenerateCaptcha(c *gin.Context) {
 
 func SendSms(c *gin.Context) {
 	mobile := c.Query("phone")
-	accessKeyId := xenv.Getenv("ALIBABA_CLOUD_ACCESS_KEY_ID").String()
-	accessKeySecret := xenv.Getenv("ALIBABA_CLOUD_ACCESS_KEY_SECRET").String()
-	cfg := &openapi.Config{
-		// 您的AccessKey ID
-		AccessKeyId: &accessKeyId,
-		// 您的AccessKey Secret
-		AccessKeySecret: &accessKeySecret,
-		// 访问的域名
-	}
-	cfg.Endpoint = tea.String("dysmsapi.aliyuncs.com")
-
-	client, _ := dysmsapi.NewClient(cfg)
-
 	smsCode := generateSmsCode(6)
 	//smsCode := "1234"
 
-	request := &dysmsapi.SendSmsRequest{}
-	request.SetPhoneNumbers(mobile)
-	request.SetSignName("阿里云短信测试")
-	request.SetTemplateCode("SMS_154950909")
-	request.SetTemplateParam(fmt.Sprintf("{\"code\":%s}", smsCode))
-
-	response, err := client.SendSms(request)
+	err := sendSmsCode(mobile, smsCode)
 	if err != nil {
 		di.Zap().Errorf("failed to send sms: %s", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -94,14 +102,6 @@ func SendSms(c *gin.Context) {
 		return
 	}
 
-	if *response.StatusCode != http.StatusOK {
-		di.Zap().Errorf("failed to send sms: %s", *response.Body.Message)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": err.Error(),
-		})
-		return
-	}
-
 	_, err = di.GoRedis().Set(context.Background(), mobile, smsCode, time.Duration(config.Config.Expire)*time.Second).Result()
 	if err != nil {
 		di.Zap().Errorf("failed to save the value of %s: %s", smsCode, err)
@@ -161,6 +161,15 @@ func (t *UserController) Login(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"message": "failed to create token",
 		})
+		return
+	}
+	refreshToken, err := di.IssueRefreshToken(c.Request.Context(), user.ID)
+	if err != nil {
+		di.Zap().Errorf("failed to issue refresh token: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed to create token",
+		})
+		return
 	}
 
 	respData := map[string]any{
@@ -170,13 +179,16 @@ func (t *UserController) Login(c *gin.Context) {
 		"phone":    user.Phone,
 		"profile":  user.Profile,
 		"birthDay": user.BirthDay,
+		// a pending deletion can be cancelled after signing in
+		"deletionScheduledAt": user.DeletionScheduledAt,
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":     "ok",
-		"accessToken": token,
-		"expireIn":    7200,
-		"data":        respData,
+		"message":      "ok",
+		"accessToken":  token,
+		"expireIn":     int(accessTokenTTL().Seconds()),
+		"refreshToken": refreshToken,
+		"data":         respData,
 	})
 }
 
@@ -248,36 +260,228 @@ func (t *UserController) Post(c *gin.Context) {
 		})
 		return
 	}
+	refreshToken, err := di.IssueRefreshToken(c.Request.Context(), user.ID)
+	if err != nil {
+		di.Zap().Errorf("failed to issue refresh token: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Creation of token fails",
+		})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":      "ok",
-		"access_token": token,
-		"expire_in":    7200,
-		"data":         user,
+		"message":       "ok",
+		"access_token":  token,
+		"expire_in":     int(accessTokenTTL().Seconds()),
+		"refresh_token": refreshToken,
+		"data":          user,
 	})
 }
 
 func (t *UserController) Get(c *gin.Context) {
+	viewerID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	uid, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid user id"})
+		return
+	}
+
+	user := models.User{}
+	res := di.Gorm().First(&user, uid)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("user %d not found", uid)})
+		} else {
+			di.Zap().Errorf("failed to query user: %s", res.Error)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		}
+		return
+	}
+	self := user.ID == viewerID
+
+	// the owner also sees its private textbooks
+	var textbooks []profileTextbook
+	query := di.Gorm().Model(&models.Textbook{}).Where("author_id = ?", user.ID)
+	if !self {
+		query = query.Where("is_private = ?", false)
+	}
+	if res = query.Order("created_at DESC").Find(&textbooks); res.Error != nil {
+		di.Zap().Errorf("failed to query textbooks of user %d: %s", user.ID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	followers, following, isFollowing, err := followStats(user.ID, viewerID)
+	if err != nil {
+		di.Zap().Errorf("failed to count follows of user %d: %s", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	data := gin.H{
+		"id":        user.ID,
+		"username":  user.Username,
+		"avatar":    user.Avatar,
+		"profile":   user.Profile,
+		"createdAt": user.CreatedAt,
+		"textbooks": textbooks,
+		"counts": gin.H{
+			"textbooks": len(textbooks),
+			"followers": followers,
+			"following": following,
+		},
+	}
+	if self {
+		data["phone"] = user.Phone
+		data["email"] = user.Email
+		data["birthDay"] = user.BirthDay
+		data["updatedAt"] = user.UpdatedAt
+	} else {
+		data["isFollowing"] = isFollowing
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"status":  http.StatusOK,
-		"message": "ok",
+		"message": "OK",
+		"data":    data,
 	})
 }
 
+// Put updates the profile of the current user, a new email or phone only takes
+// effect once the code sent to it is confirmed through VerifyContact
 func (t *UserController) Put(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	if c.Param("id") != strconv.FormatUint(uint64(userID), 10) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return
+	}
+	uf := userUpdateForm{}
+	if err := c.ShouldBindJSON(&uf); err != nil {
+		if fields, ok := fieldErrors(err); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry", "errors": fields})
+			return
+		}
+		di.Zap().Errorf("failed to bind form: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+		return
+	}
+	fields := gin.H{}
+	if uf.Username != nil && strings.TrimSpace(*uf.Username) == "" {
+		fields["username"] = "required"
+	}
+	if uf.Phone != nil && *uf.Phone == "" {
+		fields["phone"] = "required"
+	}
+	if len(fields) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry", "errors": fields})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"status":  http.StatusOK,
-		"message": "ok",
-	})
-}
+	user := models.User{}
+	if res := di.Gorm().First(&user, userID); res.Error != nil {
+		di.Zap().Errorf("failed to query user %d: %s", userID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
 
-func (t *UserController) Delete(c *gin.Context) {
+	updates := map[string]any{}
+	pending := map[string]string{}
+	if uf.Username != nil && *uf.Username != user.Username {
+		updates["username"] = strings.TrimSpace(*uf.Username)
+	}
+	if uf.Profile != nil {
+		updates["profile"] = *uf.Profile
+	}
+	if uf.Avatar != nil {
+		updates["avatar"] = *uf.Avatar
+	}
+	if uf.BirthDay != nil {
+		if *uf.BirthDay == "" {
+			updates["birth_day"] = nil
+		} else {
+			birthDay, _ := time.Parse("2006-01-02", *uf.BirthDay)
+			updates["birth_day"] = birthDay
+		}
+	}
+	if uf.Email != nil && *uf.Email != user.Email {
+		if *uf.Email == "" {
+			updates["email"] = ""
+		} else {
+			pending["email"] = *uf.Email
+		}
+	}
+	if uf.Phone != nil && *uf.Phone != user.Phone {
+		pending["phone"] = *uf.Phone
+	}
+
+	// conflicts are reported before anything changes
+	for _, field := range []string{"username", "email", "phone"} {
+		value, ok := updates[field]
+		if !ok {
+			value, ok = pending[field]
+		}
+		if !ok {
+			continue
+		}
+		taken, err := userFieldTaken(field, value, userID)
+		if err != nil {
+			di.Zap().Errorf("failed to check %s of users: %s", field, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+			return
+		}
+		if taken {
+			c.JSON(http.StatusConflict, gin.H{
+				"message": fmt.Sprintf("%s is already in use", field),
+				"errors":  gin.H{field: "taken"},
+			})
+			return
+		}
+	}
+
+	for field, value := range pending {
+		if err := startContactChange(c.Request.Context(), userID, field, value); err != nil {
+			di.Zap().Errorf("failed to send %s verification code to user %d: %s", field, userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to send verification code"})
+			return
+		}
+	}
+	if len(updates) > 0 {
+		res := di.Gorm().Model(&user).Updates(updates)
+		if isDuplicateKey(res.Error) {
+			c.JSON(http.StatusConflict, gin.H{"message": "username or email is already in use"})
+			return
+		}
+		if res.Error != nil {
+			di.Zap().Errorf("failed to update user %d: %s", userID, res.Error)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+			return
+		}
+	}
+
+	verifying := make([]string, 0, len(pending))
+	for field := range pending {
+		verifying = append(verifying, field)
+	}
+	sort.Strings(verifying)
+	di.Gorm().First(&user, userID)
 
 	c.JSON(http.StatusOK, gin.H{
-		"status":  http.StatusOK,
-		"message": "ok",
+		"message": "OK",
+		"data": gin.H{
+			"id":        user.ID,
+			"username":  user.Username,
+			"phone":     user.Phone,
+			"email":     user.Email,
+			"birthDay":  user.BirthDay,
+			"profile":   user.Profile,
+			"avatar":    user.Avatar,
+			"updatedAt": user.UpdatedAt,
+		},
+		"pending": verifying,
 	})
 }
 
@@ -297,15 +501,73 @@ func generateSmsCode(width int) string {
 	return sb.String()
 }
 
+// sendSmsCode sends a verification code to mobile
+func sendSmsCode(mobile, code string) error {
+	accessKeyId := xenv.Getenv("ALIBABA_CLOUD_ACCESS_KEY_ID").String()
+	accessKeySecret := xenv.Getenv("ALIBABA_CLOUD_ACCESS_KEY_SECRET").String()
+	cfg := &openapi.Config{
+		// 您的AccessKey ID
+		AccessKeyId: &accessKeyId,
+		// 您的AccessKey Secret
+		AccessKeySecret: &accessKeySecret,
+		// 访问的域名
+	}
+	cfg.Endpoint = tea.String("dysmsapi.aliyuncs.com")
+
+	client, err := dysmsapi.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	request := &dysmsapi.SendSmsRequest{}
+	request.SetPhoneNumbers(mobile)
+	request.SetSignName("阿里云短信测试")
+	request.SetTemplateCode("SMS_154950909")
+	request.SetTemplateParam(fmt.Sprintf("{\"code\":%s}", code))
+
+	response, err := client.SendSms(request)
+	if err != nil {
+		return err
+	}
+	if *response.StatusCode != http.StatusOK {
+		return errors.New(tea.StringValue(response.Body.Message))
+	}
+	return nil
+}
+
+// accessTokenTTL is kept short, clients renew access tokens through auth/refresh
+func accessTokenTTL() time.Duration {
+	return time.Duration(xenv.Getenv("ACCESS_TOKEN_TTL").Int64(900)) * time.Second
+}
+
 func generateToken(user *models.User) (string, error) {
-	now := time.Now().Unix()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"iss": "http://hammer.wang",                                  // 签发人
-		"iat": now,                                                   // 签发时间
-		"exp": now + int64(7200),                                     // 过期时间
-		"nbf": time.Date(2015, 10, 10, 12, 0, 0, 0, time.UTC).Unix(), // 什么时间之前不可用
-		"uid": user.ID,
+	ver, err := di.TokenVersion(context.Background(), user.ID)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &middleware.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    middleware.TokenIssuer(),                      // 签发人
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),       // 用户
+			Audience:  jwt.ClaimStrings{middleware.TokenAudience()},  // 接收方
+			IssuedAt:  jwt.NewNumericDate(now),                       // 签发时间
+			NotBefore: jwt.NewNumericDate(now),                       // 什么时间之前不可用
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL())), // 过期时间
+			ID:        randomHex(16),                                 // 注销时加入黑名单
+		},
+		UserID:  user.ID,
+		Roles:   user.Roles,
+		Version: ver, // 低于用户当前版本的token全部失效
 	})
 
-	return token.SignedString([]byte(xenv.Getenv("HMAC_SECRET").String()))
+	// the shared secret is only used until the first key is generated
+	key := di.JWTKeys().Current()
+	if key == nil {
+		return token.SignedString([]byte(xenv.Getenv("HMAC_SECRET").String()))
+	}
+	token.Method = key.Method()
+	token.Header["alg"] = key.Alg
+	token.Header["kid"] = key.Kid
+	return token.SignedString(key.Private())
 }
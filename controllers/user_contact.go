@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/go-sql-driver/mysql"
+	"github.com/redis/go-redis/v9"
+	"hammer-web-api/config"
+	"hammer-web-api/di"
+	"hammer-web-api/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const contactMaxAttempts = 5
+
+type contactVerifyForm struct {
+	Field string `json:"field" binding:"required,oneof=email phone"`
+	Code  string `json:"code" binding:"required"`
+}
+
+// VerifyContact applies a pending email or phone change once its code is confirmed
+func (t *UserController) VerifyContact(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	if c.Param("id") != strconv.FormatUint(uint64(userID), 10) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return
+	}
+	vf := contactVerifyForm{}
+	if err := c.ShouldBindJSON(&vf); err != nil {
+		if fields, ok := fieldErrors(err); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry", "errors": fields})
+			return
+		}
+		di.Zap().Errorf("failed to bind form: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+		return
+	}
+	ctx := c.Request.Context()
+	key := contactKey(userID, vf.Field)
+
+	pending, attempts, err := countContactAttempt(ctx, key)
+	if err != nil {
+		di.Zap().Errorf("failed to count attempts of user %d: %s", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	if pending == nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("no pending %s change", vf.Field)})
+		return
+	}
+	if attempts > contactMaxAttempts {
+		di.GoRedis().Del(ctx, key)
+		c.JSON(http.StatusTooManyRequests, gin.H{"message": "too many attempts, please request a new code"})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(vf.Code), []byte(pending["code"])) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "wrong verification code"})
+		return
+	}
+
+	value := pending["value"]
+	taken, err := userFieldTaken(vf.Field, value, userID)
+	if err == nil && !taken {
+		err = di.Gorm().Model(&models.User{}).Where("id = ?", userID).Update(vf.Field, value).Error
+		taken = isDuplicateKey(err)
+	}
+	if taken {
+		di.GoRedis().Del(ctx, key)
+		c.JSON(http.StatusConflict, gin.H{
+			"message": fmt.Sprintf("%s is already in use", vf.Field),
+			"errors":  gin.H{vf.Field: "taken"},
+		})
+		return
+	}
+	if err != nil {
+		di.Zap().Errorf("failed to update %s of user %d: %s", vf.Field, userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	di.GoRedis().Del(ctx, key)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    gin.H{vf.Field: value},
+	})
+}
+
+// countContactAttempt reads a pending change and counts an attempt at its code in one
+// transaction, so an attempt never recreates a change that expired in between. It returns
+// nil when there is no pending change
+func countContactAttempt(ctx context.Context, key string) (map[string]string, int64, error) {
+	rdb := di.GoRedis()
+	for i := 0; i < 3; i++ {
+		var pending map[string]string
+		var attempts *redis.IntCmd
+		err := rdb.Watch(ctx, func(tx *redis.Tx) error {
+			p, err := tx.HGetAll(ctx, key).Result()
+			if err != nil || len(p) == 0 {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				attempts = pipe.HIncrBy(ctx, key, "attempts", 1)
+				return nil
+			})
+			pending = p
+			return err
+		}, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil || pending == nil {
+			return nil, 0, err
+		}
+		return pending, attempts.Val(), nil
+	}
+	return nil, 0, errors.New("pending change is too busy")
+}
+
+// startContactChange keeps the new value of field aside and sends a code to it,
+// a later change of the same field replaces the pending one
+func startContactChange(ctx context.Context, userID uint, field, value string) error {
+	code := generateSmsCode(6)
+	ttl := contactCodeTTL()
+	key := contactKey(userID, field)
+	_, err := di.GoRedis().TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Del(ctx, key)
+		p.HSet(ctx, key, "value", value, "code", code, "attempts", 0)
+		p.Expire(ctx, key, ttl)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if field == "phone" {
+		return sendSmsCode(value, code)
+	}
+	return di.Mailer().Send(value, "Verify your email address",
+		fmt.Sprintf("Your verification code is %s, it expires in %d minutes.", code, int(ttl.Minutes())))
+}
+
+func contactCodeTTL() time.Duration {
+	if config.Config.Expire > 0 {
+		return time.Duration(config.Config.Expire) * time.Second
+	}
+	return 10 * time.Minute
+}
+
+func contactKey(userID uint, field string) string {
+	return fmt.Sprintf("contact:%d:%s", userID, field)
+}
+
+// userFieldTaken reports whether another user already has value in column field
+func userFieldTaken(field string, value any, userID uint) (bool, error) {
+	var n int64
+	err := di.Gorm().Model(&models.User{}).Where(field+" = ? AND id <> ?", value, userID).Count(&n).Error
+	return n > 0, err
+}
+
+func isDuplicateKey(err error) bool {
+	var me *mysql.MySQLError
+	return errors.As(err, &me) && me.Number == 1062
+}
+
+// fieldErrors maps validation errors to the json name of each field and the failed rule
+func fieldErrors(err error) (gin.H, bool) {
+	var ve validator.ValidationErrors
+	if !errors.As(err, &ve) {
+		return nil, false
+	}
+	fields := gin.H{}
+	for _, fe := range ve {
+		name := fe.Field()
+		name = strings.ToLower(name[:1]) + name[1:]
+		if fe.Param() != "" {
+			fields[name] = fe.Tag() + "=" + fe.Param()
+		} else {
+			fields[name] = fe.Tag()
+		}
+	}
+	return fields, true
+}
@@ -0,0 +1,323 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/mix-go/xutil/xenv"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"hammer-web-api/di"
+	"hammer-web-api/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	accountDeletionBatchSize   = 20
+	accountDeletionMaxAttempts = 5
+)
+
+var errTooManyAttempts = errors.New("too many attempts")
+
+type accountDeletionForm struct {
+	Password   string `json:"password"`
+	SmsCode    string `json:"smsCode"`
+	Policy     string `json:"policy" binding:"required,oneof=archive transfer"`
+	TransferTo *uint  `json:"transferTo"`
+}
+
+// Delete schedules the deletion of the current account after a grace period,
+// it is confirmed with the password or a code sent to the phone through GET users/sms
+func (t *UserController) Delete(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	if c.Param("id") != strconv.FormatUint(uint64(userID), 10) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return
+	}
+	df := accountDeletionForm{}
+	if err := c.ShouldBindJSON(&df); err != nil {
+		if fields, ok := fieldErrors(err); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry", "errors": fields})
+			return
+		}
+		di.Zap().Errorf("failed to bind form: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+		return
+	}
+	if df.Password == "" && df.SmsCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "password or sms code is required"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	user := models.User{}
+	if res := di.Gorm().First(&user, userID); res.Error != nil {
+		di.Zap().Errorf("failed to query user %d: %s", userID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	if user.DeletionScheduledAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"message": "account deletion is already scheduled"})
+		return
+	}
+
+	confirmed := false
+	if df.Password != "" {
+		confirmed = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(df.Password)) == nil
+	} else {
+		var err error
+		confirmed, err = checkDeletionCode(ctx, &user, df.SmsCode)
+		if errors.Is(err, errTooManyAttempts) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"message": "too many attempts, please request a new code"})
+			return
+		}
+		if err != nil {
+			di.Zap().Errorf("failed to check sms code of user %d: %s", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+			return
+		}
+	}
+	if !confirmed {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "wrong password or sms code"})
+		return
+	}
+
+	if df.Policy == models.DeletionTransfer {
+		if df.TransferTo == nil || *df.TransferTo == userID {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "please choose another user to transfer textbooks to"})
+			return
+		}
+		var target models.User
+		if di.Gorm().Select("id").Where("id = ? AND deletion_scheduled_at IS NULL", *df.TransferTo).First(&target).RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("user %d not found", *df.TransferTo)})
+			return
+		}
+	} else {
+		df.TransferTo = nil
+	}
+
+	scheduledAt := time.Now().Add(time.Duration(xenv.Getenv("ACCOUNT_DELETION_GRACE_DAYS").Int64(14)) * 24 * time.Hour)
+	res := di.Gorm().Model(&user).Updates(map[string]any{
+		"deletion_scheduled_at":   scheduledAt,
+		"deletion_policy":         df.Policy,
+		"deletion_transfer_to_id": df.TransferTo,
+	})
+	if res.Error != nil {
+		di.Zap().Errorf("failed to schedule deletion of user %d: %s", userID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	// signing in again is needed to cancel
+	if err := di.RevokeUserTokens(ctx, userID); err != nil {
+		di.Zap().Errorf("failed to revoke tokens of user %d: %s", userID, err)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "OK",
+		"data": gin.H{
+			"deletionScheduledAt": scheduledAt,
+			"policy":              df.Policy,
+			"transferTo":          df.TransferTo,
+		},
+	})
+}
+
+// checkDeletionCode compares code with the sms code sent to the phone of user,
+// too many wrong guesses burn the code and a matching code can only be used once
+func checkDeletionCode(ctx context.Context, user *models.User, code string) (bool, error) {
+	key := fmt.Sprintf("deletion:attempts:%d", user.ID)
+	attempts, err := di.GoRedis().Incr(ctx, key).Result()
+	if err == nil && attempts == 1 {
+		err = di.GoRedis().Expire(ctx, key, contactCodeTTL()).Err()
+	}
+	if err != nil {
+		return false, err
+	}
+	if attempts > accountDeletionMaxAttempts {
+		di.GoRedis().Del(ctx, user.Phone)
+		return false, errTooManyAttempts
+	}
+
+	want, err := di.GoRedis().Get(ctx, user.Phone).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if subtle.ConstantTimeCompare([]byte(want), []byte(code)) != 1 {
+		return false, nil
+	}
+	// whoever deletes the code uses it
+	if n, err := di.GoRedis().Del(ctx, user.Phone).Result(); err != nil || n == 0 {
+		return false, err
+	}
+	di.GoRedis().Del(ctx, key)
+	return true, nil
+}
+
+// CancelDeletion keeps the account when its deletion is still in the grace period
+func (t *UserController) CancelDeletion(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	if c.Param("id") != strconv.FormatUint(uint64(userID), 10) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "request no permission"})
+		return
+	}
+
+	res := di.Gorm().Model(&models.User{}).
+		Where("id = ? AND deletion_scheduled_at IS NOT NULL AND anonymized_at IS NULL", userID).
+		Updates(map[string]any{
+			"deletion_scheduled_at":   nil,
+			"deletion_policy":         nil,
+			"deletion_transfer_to_id": nil,
+		})
+	if res.Error != nil {
+		di.Zap().Errorf("failed to cancel deletion of user %d: %s", userID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "no account deletion is scheduled"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+	})
+}
+
+// RunAccountDeletionWorker anonymizes the accounts whose grace period is over until ctx is done
+func RunAccountDeletionWorker(ctx context.Context) {
+	interval := time.Duration(xenv.Getenv("ACCOUNT_DELETION_POLL_INTERVAL").Int64(3600)) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for processAccountDeletions(ctx) == accountDeletionBatchSize {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func processAccountDeletions(ctx context.Context) int {
+	var due []models.User
+	res := di.Gorm().Where("deletion_scheduled_at <= ? AND anonymized_at IS NULL", time.Now()).
+		Order("deletion_scheduled_at").Limit(accountDeletionBatchSize).Find(&due)
+	if res.Error != nil {
+		di.Zap().Errorf("failed to query due account deletions: %s", res.Error)
+		return 0
+	}
+	for _, user := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		textbooks, err := anonymizeUser(user)
+		if err != nil {
+			di.Zap().Errorf("failed to delete account of user %d: %s", user.ID, err)
+			continue
+		}
+		for _, tid := range textbooks {
+			textbookCache.Invalidate(context.Background(), tid)
+		}
+		if err = di.RevokeUserTokens(context.Background(), user.ID); err != nil {
+			di.Zap().Errorf("failed to revoke tokens of user %d: %s", user.ID, err)
+		}
+	}
+	return len(due)
+}
+
+// anonymizeUser handles the textbooks of user according to its policy, drops its social data
+// and personal fields and soft deletes it, it returns the textbooks that changed hands or were archived
+func anonymizeUser(user models.User) ([]uint, error) {
+	var changed []uint
+	err := di.Gorm().Transaction(func(tx *gorm.DB) error {
+		// claim the account, another instance may be on it already
+		now := time.Now()
+		res := tx.Model(&models.User{}).Where("id = ? AND anonymized_at IS NULL", user.ID).Update("anonymized_at", now)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+
+		var textbooks []models.Textbook
+		if err := tx.Select("id", "title", "collaborator_id").Where("author_id = ?", user.ID).Find(&textbooks).Error; err != nil {
+			return err
+		}
+		target := uint(0)
+		if user.DeletionPolicy == models.DeletionTransfer && user.DeletionTransferToID != nil {
+			var u models.User
+			if tx.Select("id").Where("id = ? AND deletion_scheduled_at IS NULL", *user.DeletionTransferToID).First(&u).RowsAffected > 0 {
+				target = u.ID
+			}
+		}
+		for _, textbook := range textbooks {
+			changed = append(changed, textbook.ID)
+			// a textbook the new author already has a title for is archived like the rest
+			if target != 0 && tx.Select("id").Where("author_id = ? AND title = ?", target, textbook.Title).
+				First(&models.Textbook{}).RowsAffected == 0 {
+				updates := map[string]any{"author_id": target}
+				if textbook.CollaboratorID != nil && *textbook.CollaboratorID == target {
+					updates["collaborator_id"] = nil
+				}
+				if err := tx.Model(&textbook).Updates(updates).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if err := tx.Delete(&textbook).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&models.Textbook{}).Where("collaborator_id = ?", user.ID).Update("collaborator_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("follower_id = ? OR followee_id = ?", user.ID, user.ID).Delete(&models.Follow{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.Notification{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("owner_id = ?", user.ID).Delete(&models.Webhook{}).Error; err != nil {
+			return err
+		}
+		// the export worker removes the files of exports that have expired
+		err := tx.Model(&models.DataExport{}).Where("user_id = ? AND status = ?", user.ID, models.ExportReady).
+			Update("expires_at", now).Error
+		if err != nil {
+			return err
+		}
+
+		// unique columns get placeholders derived from the id
+		err = tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]any{
+			"username":  fmt.Sprintf("deleted-%d", user.ID),
+			"phone":     fmt.Sprintf("%011d", user.ID),
+			"email":     nil,
+			"avatar":    "",
+			"profile":   "",
+			"birth_day": nil,
+			"password":  "",
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&models.User{}, user.ID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
@@ -0,0 +1,346 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"hammer-web-api/di"
+	"hammer-web-api/models"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type WebhookController struct {
+}
+
+type webhookForm struct {
+	URL        string   `json:"url" binding:"required,url,max=255"`
+	Secret     string   `json:"secret" binding:"omitempty,min=16,max=64"`
+	Events     []string `json:"events" binding:"required,min=1,dive,oneof=* textbook.created version.published textbook.deleted"`
+	TextbookID *uint    `json:"textbookID"`
+	Active     *bool    `json:"active"`
+}
+
+func (t *WebhookController) Post(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	hook := models.Webhook{OwnerID: userID, Active: true}
+	if !bindWebhook(c, &hook) {
+		return
+	}
+	// the secret is shown once, generate one when none was given
+	if hook.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			di.Zap().Errorf("failed to generate webhook secret: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+			return
+		}
+		hook.Secret = hex.EncodeToString(b)
+	}
+
+	if res := di.Gorm().Create(&hook); res.Error != nil {
+		di.Zap().Errorf("failed to create webhook: %s", res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "OK",
+		"data": gin.H{
+			"webhook": hook,
+			"secret":  hook.Secret,
+		},
+	})
+}
+
+func (t *WebhookController) GetList(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var hooks []models.Webhook
+	if res := di.Gorm().Where("owner_id = ?", userID).Order("id").Find(&hooks); res.Error != nil {
+		di.Zap().Errorf("failed to query webhooks: %s", res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    hooks,
+	})
+}
+
+func (t *WebhookController) Get(c *gin.Context) {
+	hook, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    hook,
+	})
+}
+
+func (t *WebhookController) Put(c *gin.Context) {
+	hook, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+	secret := hook.Secret
+	if !bindWebhook(c, &hook) {
+		return
+	}
+	if hook.Secret == "" {
+		hook.Secret = secret
+	}
+
+	res := di.Gorm().Model(&hook).Select("url", "secret", "events", "active", "textbook_id").Updates(&hook)
+	if res.Error != nil {
+		di.Zap().Errorf("failed to update webhook %d: %s", hook.ID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    hook,
+	})
+}
+
+func (t *WebhookController) Delete(c *gin.Context) {
+	hook, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	if res := di.Gorm().Delete(&hook); res.Error != nil {
+		di.Zap().Errorf("failed to delete webhook %d: %s", hook.ID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+	})
+}
+
+func (t *WebhookController) GetDeliveries(c *gin.Context) {
+	hook, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 || size > 100 {
+		size = 20
+	}
+
+	query := di.Gorm().Model(&models.WebhookDelivery{}).Where("webhook_id = ?", hook.ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var total int64
+	if res := query.Count(&total); res.Error != nil {
+		di.Zap().Errorf("failed to count deliveries: %s", res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	var deliveries []models.WebhookDelivery
+	if res := query.Order("id DESC").Offset((page - 1) * size).Limit(size).Find(&deliveries); res.Error != nil {
+		di.Zap().Errorf("failed to query deliveries: %s", res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    deliveries,
+		"total":   total,
+	})
+}
+
+func (t *WebhookController) Redeliver(c *gin.Context) {
+	hook, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+	var original models.WebhookDelivery
+	if di.Gorm().Where("id = ? AND webhook_id = ?", c.Param("did"), hook.ID).First(&original).RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("delivery %s not found", c.Param("did"))})
+		return
+	}
+
+	// a redelivery is a new delivery of the same payload, the original log stays as it was
+	now := time.Now()
+	delivery := models.WebhookDelivery{
+		Event:         original.Event,
+		Payload:       original.Payload,
+		Status:        models.DeliveryPending,
+		NextAttemptAt: &now,
+		WebhookID:     hook.ID,
+		RedeliveryOf:  &original.ID,
+	}
+	if res := di.Gorm().Create(&delivery); res.Error != nil {
+		di.Zap().Errorf("failed to create redelivery of %d: %s", original.ID, res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		return
+	}
+	wakeWebhookWorker()
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "OK",
+		"data":    delivery,
+	})
+}
+
+// bindWebhook binds the form into hook, a textbook filter must be readable by the owner
+func bindWebhook(c *gin.Context, hook *models.Webhook) bool {
+	wf := webhookForm{}
+	if err := c.ShouldBindJSON(&wf); err != nil {
+		di.Zap().Errorf("failed to bind form: %s", err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": "please check your entry"})
+		return false
+	}
+	u, err := url.Parse(wf.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "url must be http or https"})
+		return false
+	}
+	// names are checked again when delivering, once they are resolved
+	if ip := net.ParseIP(u.Hostname()); (ip != nil && !webhookAddressAllowed(ip)) ||
+		(strings.EqualFold(u.Hostname(), "localhost") && !webhookAddressAllowed(net.IPv4(127, 0, 0, 1))) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "url must point to a public address"})
+		return false
+	}
+	if wf.TextbookID != nil {
+		res := di.Gorm().Where("id = ? AND (is_private = ? OR author_id = ?)", *wf.TextbookID, false, hook.OwnerID).
+			First(&models.Textbook{})
+		if res.RowsAffected == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "textbook does not exist or is private"})
+			return false
+		}
+	}
+
+	hook.URL = wf.URL
+	hook.Secret = wf.Secret
+	hook.Events = uniqueStrings(wf.Events)
+	hook.TextbookID = wf.TextbookID
+	if wf.Active != nil {
+		hook.Active = *wf.Active
+	}
+	return true
+}
+
+// loadWebhook loads the webhook of the route owned by the current user
+func loadWebhook(c *gin.Context) (models.Webhook, bool) {
+	var hook models.Webhook
+	userID, ok := currentUserID(c)
+	if !ok {
+		return hook, false
+	}
+	wid, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid webhook id"})
+		return hook, false
+	}
+	res := di.Gorm().Where("id = ? AND owner_id = ?", wid, userID).First(&hook)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("webhook %d not found", wid)})
+		} else {
+			di.Zap().Errorf("failed to query webhook: %s", res.Error)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "internal server error"})
+		}
+		return hook, false
+	}
+	return hook, true
+}
+
+// emitWebhookEvent queues a delivery of event for every active webhook watching the textbook,
+// textbook must have id, title, author_id and is_private loaded
+func emitWebhookEvent(event string, textbook models.Textbook, data gin.H) {
+	var hooks []models.Webhook
+	res := di.Gorm().Where("active = ? AND (textbook_id = ? OR (textbook_id IS NULL AND owner_id = ?))",
+		true, textbook.ID, textbook.AuthorID).Find(&hooks)
+	if res.Error != nil {
+		di.Zap().Errorf("failed to query webhooks for %s: %s", event, res.Error)
+		return
+	}
+
+	now := time.Now()
+	payload, err := json.Marshal(gin.H{
+		"event":     event,
+		"createdAt": now,
+		"textbook": gin.H{
+			"id":       textbook.ID,
+			"title":    textbook.Title,
+			"authorID": textbook.AuthorID,
+		},
+		"data": data,
+	})
+	if err != nil {
+		di.Zap().Errorf("failed to encode %s payload: %s", event, err)
+		return
+	}
+	var deliveries []models.WebhookDelivery
+	for _, hook := range hooks {
+		// other users stop hearing about a textbook once it turns private
+		if textbook.IsPrivate && hook.OwnerID != textbook.AuthorID {
+			continue
+		}
+		if !containsString(hook.Events, event) && !containsString(hook.Events, "*") {
+			continue
+		}
+		deliveries = append(deliveries, models.WebhookDelivery{
+			Event:         event,
+			Payload:       string(payload),
+			Status:        models.DeliveryPending,
+			NextAttemptAt: &now,
+			WebhookID:     hook.ID,
+		})
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+	if res = di.Gorm().Create(&deliveries); res.Error != nil {
+		di.Zap().Errorf("failed to queue %s deliveries: %s", event, res.Error)
+		return
+	}
+	wakeWebhookWorker()
+}
+
+func containsString(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+func uniqueStrings(s []string) []string {
+	out := make([]string, 0, len(s))
+	for _, v := range s {
+		if !containsString(out, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
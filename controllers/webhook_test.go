@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"context"
+	"hammer-web-api/models"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSendWebhook(t *testing.T) {
+	hook := &models.Webhook{Secret: "0123456789abcdef"}
+	d := &models.WebhookDelivery{Event: models.WebhookVersionPublished, Payload: `{"event":"version.published"}`}
+	d.ID = 42
+
+	var got *http.Request
+	var body []byte
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	hook.URL = server.URL
+
+	code, err := sendWebhook(context.Background(), server.Client(), hook, d)
+	if err != nil || code != http.StatusNoContent {
+		t.Fatalf("sendWebhook = %d, %v", code, err)
+	}
+	if string(body) != d.Payload {
+		t.Errorf("body = %q", body)
+	}
+	if sig := got.Header.Get("X-Hammer-Signature-256"); sig != signWebhook(hook.Secret, body) {
+		t.Errorf("signature = %q", sig)
+	}
+	if got.Header.Get("X-Hammer-Event") != d.Event || got.Header.Get("X-Hammer-Delivery") != "42" {
+		t.Errorf("headers = %v", got.Header)
+	}
+
+	status = http.StatusBadGateway
+	if code, err = sendWebhook(context.Background(), server.Client(), hook, d); err == nil || code != status {
+		t.Errorf("sendWebhook on %d = %d, %v", status, code, err)
+	}
+}
+
+func TestWebhookClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	}))
+	defer server.Close()
+	hook := &models.Webhook{URL: server.URL}
+	d := &models.WebhookDelivery{Payload: "{}"}
+
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE", "false")
+	if _, err := sendWebhook(context.Background(), webhookClient(), hook, d); err == nil {
+		t.Fatal("delivered to a loopback address")
+	}
+
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE", "true")
+	code, err := sendWebhook(context.Background(), webhookClient(), hook, d)
+	if err == nil || code != http.StatusFound {
+		t.Fatalf("redirect was followed: %d, %v", code, err)
+	}
+}
+
+func TestWebhookAddressAllowed(t *testing.T) {
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE", "false")
+	cases := map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"169.254.169.254": false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"fd00::1":         false,
+		"::ffff:10.0.0.1": false,
+	}
+	for addr, want := range cases {
+		if got := webhookAddressAllowed(net.ParseIP(addr)); got != want {
+			t.Errorf("webhookAddressAllowed(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	// HMAC-SHA256 test case 2 of RFC 4231
+	sig := signWebhook("Jefe", []byte("what do ya want for nothing?"))
+	if sig != "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843" {
+		t.Errorf("signWebhook = %s", sig)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	cases := map[uint]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		5:  8 * time.Minute,
+		20: 6 * time.Hour,
+	}
+	for attempt, want := range cases {
+		if got := webhookBackoff(attempt); got != want {
+			t.Errorf("webhookBackoff(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/mix-go/xutil/xenv"
+	"hammer-web-api/di"
+	"hammer-web-api/models"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	webhookMaxAttempts  = 8
+	webhookBaseBackoff  = 30 * time.Second
+	webhookMaxBackoff   = 6 * time.Hour
+	webhookLease        = time.Minute
+	webhookBatchSize    = 20
+	webhookResponseSize = 1024
+)
+
+// cgnatRange is shared address space of carrier grade NAT, net.IP has no predicate for it
+var cgnatRange = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+var webhookWake = make(chan struct{}, 1)
+
+// wakeWebhookWorker makes the worker look for due deliveries without waiting for the next poll
+func wakeWebhookWorker() {
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// RunWebhookWorker delivers due webhooks until ctx is done,
+// deliveries live in the database so several instances can share the work
+func RunWebhookWorker(ctx context.Context) {
+	interval := time.Duration(xenv.Getenv("WEBHOOK_POLL_INTERVAL").Int64(5)) * time.Second
+	client := webhookClient()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for processWebhookDeliveries(ctx, client) == webhookBatchSize {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-webhookWake:
+		}
+	}
+}
+
+// processWebhookDeliveries attempts one batch of due deliveries and returns how many were due
+func processWebhookDeliveries(ctx context.Context, client *http.Client) int {
+	var due []models.WebhookDelivery
+	res := di.Gorm().Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, time.Now()).
+		Order("next_attempt_at").Limit(webhookBatchSize).Find(&due)
+	if res.Error != nil {
+		di.Zap().Errorf("failed to query due webhook deliveries: %s", res.Error)
+		return 0
+	}
+	for i := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		d := &due[i]
+		// claim the delivery by pushing its next attempt out, whoever moves it first sends it
+		lease := time.Now().Add(webhookLease)
+		res = di.Gorm().Model(&models.WebhookDelivery{}).
+			Where("id = ? AND status = ? AND next_attempt_at = ?", d.ID, models.DeliveryPending, d.NextAttemptAt).
+			Update("next_attempt_at", lease)
+		if res.Error != nil || res.RowsAffected == 0 {
+			continue
+		}
+		attemptWebhookDelivery(ctx, client, d)
+	}
+	return len(due)
+}
+
+func attemptWebhookDelivery(ctx context.Context, client *http.Client, d *models.WebhookDelivery) {
+	updates := map[string]any{"attempts": d.Attempts + 1}
+
+	var hook models.Webhook
+	if di.Gorm().First(&hook, d.WebhookID).RowsAffected == 0 || !hook.Active {
+		updates["status"] = models.DeliveryFailed
+		updates["error"] = "webhook was deleted or deactivated"
+		updates["next_attempt_at"] = nil
+	} else {
+		code, err := sendWebhook(ctx, client, &hook, d)
+		updates["response_code"] = code
+		updates["error"] = ""
+		switch {
+		case err == nil:
+			updates["status"] = models.DeliverySucceeded
+			updates["delivered_at"] = time.Now()
+			updates["next_attempt_at"] = nil
+		case d.Attempts+1 >= webhookMaxAttempts:
+			updates["status"] = models.DeliveryFailed
+			updates["error"] = truncate(err.Error(), 255)
+			updates["next_attempt_at"] = nil
+		default:
+			updates["error"] = truncate(err.Error(), 255)
+			updates["next_attempt_at"] = time.Now().Add(webhookBackoff(d.Attempts + 1))
+		}
+	}
+	if res := di.Gorm().Model(d).Updates(updates); res.Error != nil {
+		di.Zap().Errorf("failed to record webhook delivery %d: %s", d.ID, res.Error)
+	}
+}
+
+// sendWebhook posts the payload of d to the webhook, any 2xx response counts as delivered
+func sendWebhook(ctx context.Context, client *http.Client, hook *models.Webhook, d *models.WebhookDelivery) (int, error) {
+	payload := []byte(d.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "hammer-web-api-webhook")
+	req.Header.Set("X-Hammer-Event", d.Event)
+	req.Header.Set("X-Hammer-Delivery", strconv.FormatUint(uint64(d.ID), 10))
+	req.Header.Set("X-Hammer-Signature-256", signWebhook(hook.Secret, payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// the body is never kept, whatever the endpoint answers must not reach the webhook owner
+	io.Copy(io.Discard, io.LimitReader(resp.Body, webhookResponseSize))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookClient only connects to public addresses and does not follow redirects,
+// webhook urls are chosen by users and must not reach the internal network
+func webhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: webhookDialControl}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// webhookDialControl runs after name resolution, so it also catches public names pointing inside
+func webhookDialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !webhookAddressAllowed(ip) {
+		return fmt.Errorf("webhook address %s is not public", host)
+	}
+	return nil
+}
+
+// webhookAddressAllowed rejects loopback, link-local and private addresses
+// unless WEBHOOK_ALLOW_PRIVATE is set for local development
+func webhookAddressAllowed(ip net.IP) bool {
+	if xenv.Getenv("WEBHOOK_ALLOW_PRIVATE").Bool(false) {
+		return true
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() || cgnatRange.Contains(ip))
+}
+
+// signWebhook returns "sha256=" followed by the hex HMAC-SHA256 of payload keyed with secret
+func signWebhook(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff is the wait after the given failed attempt, doubling from 30s up to 6h
+func webhookBackoff(attempt uint) time.Duration {
+	backoff := webhookBaseBackoff
+	for i := uint(1); i < attempt && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > webhookMaxBackoff {
+		backoff = webhookMaxBackoff
+	}
+	return backoff
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,314 @@
+package di
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"github.com/mix-go/xdi"
+	"github.com/mix-go/xutil/xenv"
+	"github.com/redis/go-redis/v9"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrCacheMiss is returned by Cache.Get when neither tier holds the key
+var ErrCacheMiss = errors.New("cache miss")
+
+const cacheInvalidateChannel = "cache:invalidate"
+
+func init() {
+	obj := xdi.Object{
+		Name: "cache",
+		New: func() (i interface{}, e error) {
+			maxBytes := xenv.Getenv("CACHE_LOCAL_MAX_BYTES").Int64(64 << 20)
+			localTTL := time.Duration(xenv.Getenv("CACHE_LOCAL_TTL").Int64(60)) * time.Second
+			return NewCache(GoRedis(), maxBytes, localTTL), nil
+		},
+	}
+	if err := xdi.Provide(&obj); err != nil {
+		panic(err)
+	}
+}
+
+func Cache() (c *TwoTierCache) {
+	if err := xdi.Populate("cache", &c); err != nil {
+		panic(err)
+	}
+	return
+}
+
+// TwoTierCache keeps recently used values in a byte bounded in-process LRU in front of redis.
+// Writes and deletes are announced over redis pub/sub so other instances drop their local copy,
+// local copies never live longer than localTTL in case an announcement is lost.
+type TwoTierCache struct {
+	rdb      *redis.Client
+	local    *lruCache
+	localTTL time.Duration
+	id       string
+
+	localHits atomic.Int64
+	redisHits atomic.Int64
+	misses    atomic.Int64
+}
+
+func NewCache(rdb *redis.Client, maxBytes int64, localTTL time.Duration) *TwoTierCache {
+	host, _ := os.Hostname()
+	c := &TwoTierCache{
+		rdb:      rdb,
+		local:    newLRUCache(maxBytes),
+		localTTL: localTTL,
+		id:       fmt.Sprintf("%s:%d:%d", host, os.Getpid(), time.Now().UnixNano()),
+	}
+	go c.subscribe()
+	return c
+}
+
+// Get returns the value of key, values must be treated as read-only
+func (c *TwoTierCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if val, ok := c.local.get(key); ok {
+		c.localHits.Add(1)
+		return val, nil
+	}
+	// an invalidation arriving while redis answers may be older than the value read
+	gen := c.local.generation(key)
+
+	pipe := c.rdb.Pipeline()
+	get := pipe.Get(ctx, key)
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		if errors.Is(err, redis.Nil) {
+			c.misses.Add(1)
+			return nil, ErrCacheMiss
+		}
+		return nil, err
+	}
+	val, err := get.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	c.redisHits.Add(1)
+	c.local.setIf(key, val, c.localExpiry(ttl.Val()), gen)
+	return val, nil
+}
+
+// Set stores val in both tiers for ttl
+func (c *TwoTierCache) Set(ctx context.Context, key string, val []byte, ttl time.Duration) error {
+	if err := c.rdb.Set(ctx, key, val, ttl).Err(); err != nil {
+		return err
+	}
+	c.local.set(key, val, c.localExpiry(ttl))
+	return c.publish(ctx, key)
+}
+
+// SetIf stores val like Set, but only while the counter at guardKey still holds guard,
+// a value loaded before the counter was bumped is dropped instead of cached
+func (c *TwoTierCache) SetIf(ctx context.Context, key string, val []byte, ttl time.Duration, guardKey string, guard int64) (bool, error) {
+	stored := false
+	err := c.rdb.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, guardKey).Int64()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if current != guard {
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.Set(ctx, key, val, ttl)
+			return nil
+		})
+		stored = err == nil
+		return err
+	}, guardKey)
+	if errors.Is(err, redis.TxFailedErr) {
+		return false, nil
+	}
+	if err != nil || !stored {
+		return false, err
+	}
+	c.local.set(key, val, c.localExpiry(ttl))
+	return true, c.publish(ctx, key)
+}
+
+// Counter returns the counter at key, 0 when it was never bumped
+func (c *TwoTierCache) Counter(ctx context.Context, key string) (int64, error) {
+	n, err := c.rdb.Get(ctx, key).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return n, err
+}
+
+// Bump increments the counter at key, ttl must outlive every value guarded by it
+func (c *TwoTierCache) Bump(ctx context.Context, key string, ttl time.Duration) error {
+	_, err := c.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Incr(ctx, key)
+		p.Expire(ctx, key, ttl)
+		return nil
+	})
+	return err
+}
+
+// Delete removes keys from redis and from the local tier of every instance
+func (c *TwoTierCache) Delete(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		c.local.delete(key)
+	}
+	if err := c.rdb.Del(ctx, keys...).Err(); err != nil {
+		return err
+	}
+	return c.publish(ctx, keys...)
+}
+
+func (c *TwoTierCache) Stats() map[string]any {
+	entries, bytes := c.local.stats()
+	return map[string]any{
+		"localHits":    c.localHits.Load(),
+		"redisHits":    c.redisHits.Load(),
+		"misses":       c.misses.Load(),
+		"localEntries": entries,
+		"localBytes":   bytes,
+	}
+}
+
+func (c *TwoTierCache) localExpiry(ttl time.Duration) time.Time {
+	if ttl <= 0 || ttl > c.localTTL {
+		ttl = c.localTTL
+	}
+	return time.Now().Add(ttl)
+}
+
+// publish announces changed keys as "<instance id> <key>"
+func (c *TwoTierCache) publish(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		if err := c.rdb.Publish(ctx, cacheInvalidateChannel, c.id+" "+key).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *TwoTierCache) subscribe() {
+	sub := c.rdb.Subscribe(context.Background(), cacheInvalidateChannel)
+	for msg := range sub.Channel() {
+		origin, key, ok := strings.Cut(msg.Payload, " ")
+		if !ok || origin == c.id {
+			continue
+		}
+		c.local.delete(key)
+	}
+}
+
+// lruGenerations is the number of generation counters deletes are spread over
+const lruGenerations = 256
+
+// lruCache is a least recently used cache bounded by the bytes of its keys and values.
+// Every delete bumps the generation of its key, shared with the keys hashing alike
+type lruCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	ll       *list.List
+	items    map[string]*list.Element
+	gens     [lruGenerations]uint64
+}
+
+type lruEntry struct {
+	key     string
+	val     []byte
+	expires time.Time
+}
+
+func newLRUCache(maxBytes int64) *lruCache {
+	return &lruCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (l *lruCache) get(key string) ([]byte, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	el, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		l.remove(el)
+		return nil, false
+	}
+	l.ll.MoveToFront(el)
+	return entry.val, true
+}
+
+func (l *lruCache) set(key string, val []byte, expires time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.store(key, val, expires)
+}
+
+// generation is what setIf checks before caching a value read elsewhere
+func (l *lruCache) generation(key string) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.gens[lruShard(key)]
+}
+
+// setIf stores val unless key was deleted since its generation was taken
+func (l *lruCache) setIf(key string, val []byte, expires time.Time, gen uint64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.gens[lruShard(key)] != gen {
+		return false
+	}
+	l.store(key, val, expires)
+	return true
+}
+
+func (l *lruCache) store(key string, val []byte, expires time.Time) {
+	if el, ok := l.items[key]; ok {
+		l.remove(el)
+	}
+	size := int64(len(key) + len(val))
+	if size > l.maxBytes {
+		return
+	}
+	l.items[key] = l.ll.PushFront(&lruEntry{key: key, val: val, expires: expires})
+	l.bytes += size
+	for l.bytes > l.maxBytes {
+		l.remove(l.ll.Back())
+	}
+}
+
+func (l *lruCache) delete(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.gens[lruShard(key)]++
+	if el, ok := l.items[key]; ok {
+		l.remove(el)
+	}
+}
+
+func (l *lruCache) stats() (int, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.ll.Len(), l.bytes
+}
+
+func lruShard(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32() % lruGenerations
+}
+
+func (l *lruCache) remove(el *list.Element) {
+	entry := l.ll.Remove(el).(*lruEntry)
+	delete(l.items, entry.key)
+	l.bytes -= int64(len(entry.key) + len(entry.val))
+}
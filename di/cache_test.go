@@ -0,0 +1,64 @@
+package di
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUCacheEvictsByBytes(t *testing.T) {
+	l := newLRUCache(30)
+	later := time.Now().Add(time.Minute)
+	l.set("a", make([]byte, 9), later)
+	l.set("b", make([]byte, 9), later)
+	l.set("c", make([]byte, 9), later)
+
+	// touching a makes b the least recently used entry
+	if _, ok := l.get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+	l.set("d", make([]byte, 9), later)
+	if _, ok := l.get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := l.get(key); !ok {
+			t.Errorf("expected %s to be cached", key)
+		}
+	}
+	if entries, bytes := l.stats(); entries != 3 || bytes != 30 {
+		t.Errorf("expected 3 entries of 30 bytes, got %d entries of %d bytes", entries, bytes)
+	}
+
+	// values larger than the whole cache are never stored
+	l.set("e", make([]byte, 40), later)
+	if _, ok := l.get("e"); ok {
+		t.Error("expected oversized value to be skipped")
+	}
+}
+
+func TestLRUCacheExpires(t *testing.T) {
+	l := newLRUCache(100)
+	l.set("a", []byte("1"), time.Now().Add(-time.Second))
+	if _, ok := l.get("a"); ok {
+		t.Error("expected expired entry to be dropped")
+	}
+	if entries, bytes := l.stats(); entries != 0 || bytes != 0 {
+		t.Errorf("expected empty cache, got %d entries of %d bytes", entries, bytes)
+	}
+}
+
+func TestLRUCacheSetIfAfterDelete(t *testing.T) {
+	l := newLRUCache(100)
+	gen := l.generation("a")
+	// the invalidation lands while the value is still being read from redis
+	l.delete("a")
+	if l.setIf("a", []byte("stale"), time.Now().Add(time.Minute), gen) {
+		t.Error("stale value cached after a delete")
+	}
+	if _, ok := l.get("a"); ok {
+		t.Error("expected no entry")
+	}
+	if !l.setIf("a", []byte("fresh"), time.Now().Add(time.Minute), l.generation("a")) {
+		t.Error("value of the current generation not cached")
+	}
+}
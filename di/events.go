@@ -0,0 +1,221 @@
+package di
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mix-go/xdi"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	eventsChannel   = "events"
+	eventsStreamLen = 500
+	eventsRetention = 24 * time.Hour
+	eventsBuffer    = 64
+)
+
+func init() {
+	obj := xdi.Object{
+		Name: "events",
+		New: func() (i interface{}, e error) {
+			return NewEventBus(GoRedis()), nil
+		},
+	}
+	if err := xdi.Provide(&obj); err != nil {
+		panic(err)
+	}
+}
+
+func Events() (b *EventBus) {
+	if err := xdi.Populate("events", &b); err != nil {
+		panic(err)
+	}
+	return
+}
+
+// Event is pushed to the connected clients of a user, ID is the redis stream id
+type Event struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// EventBus pushes per user events to subscribers on every instance.
+// Events are appended to a capped redis stream per user so clients can resume after
+// reconnecting, and announced over redis pub/sub to the instances holding connections.
+type EventBus struct {
+	rdb *redis.Client
+
+	mu   sync.Mutex
+	subs map[uint]map[*EventSubscription]struct{}
+	once sync.Once
+}
+
+// EventSubscription receives the live events of a user, C is closed when the
+// subscriber falls too far behind and has to resume from its last event id
+type EventSubscription struct {
+	C      <-chan Event
+	ch     chan Event
+	userID uint
+	closed bool
+}
+
+func NewEventBus(rdb *redis.Client) *EventBus {
+	return &EventBus{
+		rdb:  rdb,
+		subs: make(map[uint]map[*EventSubscription]struct{}),
+	}
+}
+
+// Publish stores an event for userID and announces it to every instance
+func (b *EventBus) Publish(ctx context.Context, userID uint, typ string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	key := eventsKey(userID)
+	id, err := b.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		MaxLen: eventsStreamLen,
+		Approx: true,
+		Values: map[string]any{"type": typ, "data": raw},
+	}).Result()
+	if err != nil {
+		return err
+	}
+	b.rdb.Expire(ctx, key, eventsRetention)
+
+	msg, err := json.Marshal(struct {
+		UserID uint `json:"userID"`
+		Event
+	}{userID, Event{ID: id, Type: typ, Data: raw}})
+	if err != nil {
+		return err
+	}
+	return b.rdb.Publish(ctx, eventsChannel, msg).Err()
+}
+
+// Since returns the stored events of userID after lastID, oldest first
+func (b *EventBus) Since(ctx context.Context, userID uint, lastID string) ([]Event, error) {
+	messages, err := b.rdb.XRange(ctx, eventsKey(userID), "("+lastID, "+").Result()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]Event, 0, len(messages))
+	for _, m := range messages {
+		typ, _ := m.Values["type"].(string)
+		data, _ := m.Values["data"].(string)
+		events = append(events, Event{ID: m.ID, Type: typ, Data: json.RawMessage(data)})
+	}
+	return events, nil
+}
+
+// Subscribe starts receiving the live events of userID, Unsubscribe must be called when done
+func (b *EventBus) Subscribe(userID uint) *EventSubscription {
+	b.once.Do(func() { go b.listen() })
+	ch := make(chan Event, eventsBuffer)
+	sub := &EventSubscription{C: ch, ch: ch, userID: userID}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[userID] == nil {
+		b.subs[userID] = make(map[*EventSubscription]struct{})
+	}
+	b.subs[userID][sub] = struct{}{}
+	return sub
+}
+
+func (b *EventBus) Unsubscribe(sub *EventSubscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(sub)
+}
+
+// Connections returns the number of live subscriptions on this instance
+func (b *EventBus) Connections() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, subs := range b.subs {
+		n += len(subs)
+	}
+	return n
+}
+
+func (b *EventBus) listen() {
+	sub := b.rdb.Subscribe(context.Background(), eventsChannel)
+	for msg := range sub.Channel() {
+		var e struct {
+			UserID uint `json:"userID"`
+			Event
+		}
+		if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+			Zap().Errorf("failed to decode event: %s", err)
+			continue
+		}
+		b.dispatch(e.UserID, e.Event)
+	}
+}
+
+func (b *EventBus) dispatch(userID uint, e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs[userID] {
+		select {
+		case sub.ch <- e:
+		default:
+			b.remove(sub)
+		}
+	}
+}
+
+func (b *EventBus) remove(sub *EventSubscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.ch)
+	delete(b.subs[sub.userID], sub)
+	if len(b.subs[sub.userID]) == 0 {
+		delete(b.subs, sub.userID)
+	}
+}
+
+func eventsKey(userID uint) string {
+	return fmt.Sprintf("events:%d", userID)
+}
+
+// EventIDLess reports whether stream id a was added before b, malformed ids sort first
+func EventIDLess(a, b string) bool {
+	ams, aseq := splitEventID(a)
+	bms, bseq := splitEventID(b)
+	if ams != bms {
+		return ams < bms
+	}
+	return aseq < bseq
+}
+
+func splitEventID(id string) (uint64, uint64) {
+	msPart, seqPart, _ := strings.Cut(id, "-")
+	ms, _ := strconv.ParseUint(msPart, 10, 64)
+	seq, _ := strconv.ParseUint(seqPart, 10, 64)
+	return ms, seq
+}
+
+// ValidEventID reports whether id has the <ms>-<seq> or <ms> form of a stream id
+func ValidEventID(id string) bool {
+	msPart, seqPart, hasSeq := strings.Cut(id, "-")
+	if _, err := strconv.ParseUint(msPart, 10, 64); err != nil {
+		return false
+	}
+	if hasSeq {
+		if _, err := strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return false
+		}
+	}
+	return true
+}
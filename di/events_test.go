@@ -0,0 +1,22 @@
+package di
+
+import "testing"
+
+func TestValidEventID(t *testing.T) {
+	cases := map[string]bool{
+		"1700000000000-0": true,
+		"1700000000000":   true,
+		"0-1":             true,
+		"":                false,
+		"-":               false,
+		"1-":              false,
+		"abc":             false,
+		"1-2-3":           false,
+		"+1-0":            false,
+	}
+	for id, want := range cases {
+		if got := ValidEventID(id); got != want {
+			t.Errorf("ValidEventID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package di
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/mix-go/xcli"
+	"github.com/mix-go/xdi"
+	"github.com/mix-go/xutil/xenv"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+
+	jwtKeyManifest = "keys.json"
+	jwtKeyReload   = 10 * time.Second
+)
+
+func init() {
+	obj := xdi.Object{
+		Name: "jwtkeys",
+		New: func() (i interface{}, e error) {
+			dir := xenv.Getenv("JWT_KEY_DIR").String(fmt.Sprintf("%s/../runtime/keys", xcli.App().BasePath))
+			return NewJWTKeyStore(dir), nil
+		},
+	}
+	if err := xdi.Provide(&obj); err != nil {
+		panic(err)
+	}
+}
+
+func JWTKeys() (s *JWTKeyStore) {
+	if err := xdi.Populate("jwtkeys", &s); err != nil {
+		panic(err)
+	}
+	return
+}
+
+// JWTKey is a signing key identified by the kid header of the tokens it signs,
+// a retired key only verifies tokens until RetireAt
+type JWTKey struct {
+	Kid       string     `json:"kid"`
+	Alg       string     `json:"alg"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RetireAt  *time.Time `json:"retireAt,omitempty"`
+
+	private crypto.PrivateKey
+	public  crypto.PublicKey
+}
+
+func (k *JWTKey) Method() jwt.SigningMethod {
+	if k.Alg == AlgEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+func (k *JWTKey) Private() crypto.PrivateKey {
+	return k.private
+}
+
+func (k *JWTKey) Public() crypto.PublicKey {
+	return k.public
+}
+
+func (k *JWTKey) usable(now time.Time) bool {
+	return k.RetireAt == nil || now.Before(*k.RetireAt)
+}
+
+// JWK renders the public half of k as a json web key
+func (k *JWTKey) JWK() map[string]string {
+	jwk := map[string]string{"kid": k.Kid, "alg": k.Alg, "use": "sig"}
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk["kty"] = "OKP"
+		jwk["crv"] = "Ed25519"
+		jwk["x"] = base64.RawURLEncoding.EncodeToString(pub)
+	}
+	return jwk
+}
+
+// jwtManifest lists the keys of a key dir, each private key is stored next to it as <kid>.pem.
+// Next takes over from Current at PromoteAt, and LegacyUntil ends the hmac tokens signed
+// before the first key
+type jwtManifest struct {
+	Current     string     `json:"current"`
+	Next        string     `json:"next,omitempty"`
+	PromoteAt   *time.Time `json:"promoteAt,omitempty"`
+	Keys        []*JWTKey  `json:"keys"`
+	LegacyUntil *time.Time `json:"legacyUntil,omitempty"`
+}
+
+// current is the kid new tokens are signed with at now
+func (m *jwtManifest) current(now time.Time) string {
+	if m.Next != "" && m.PromoteAt != nil && !now.Before(*m.PromoteAt) {
+		return m.Next
+	}
+	return m.Current
+}
+
+// JWTKeyStore reads the keys from a directory shared by every instance and picks up
+// rotations made by the keys command without a restart
+type JWTKeyStore struct {
+	dir string
+
+	mu       sync.Mutex
+	manifest *jwtManifest
+	modTime  time.Time
+	checked  time.Time
+}
+
+func NewJWTKeyStore(dir string) *JWTKeyStore {
+	return &JWTKeyStore{dir: dir}
+}
+
+func (s *JWTKeyStore) load() *jwtManifest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.manifest != nil && now.Sub(s.checked) < jwtKeyReload {
+		return s.manifest
+	}
+	s.checked = now
+	info, err := os.Stat(filepath.Join(s.dir, jwtKeyManifest))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			Zap().Errorf("failed to stat jwt keys: %s", err)
+		}
+		if s.manifest == nil || os.IsNotExist(err) {
+			s.manifest = &jwtManifest{}
+		}
+		return s.manifest
+	}
+	if s.manifest != nil && info.ModTime().Equal(s.modTime) {
+		return s.manifest
+	}
+	m, err := readJWTManifest(s.dir)
+	if err != nil {
+		// keep the keys we have rather than locking everyone out
+		Zap().Errorf("failed to load jwt keys: %s", err)
+		if s.manifest == nil {
+			s.manifest = &jwtManifest{}
+		}
+		return s.manifest
+	}
+	s.manifest, s.modTime = m, info.ModTime()
+	return m
+}
+
+// Current is the key new tokens are signed with, nil when no key was generated yet
+func (s *JWTKeyStore) Current() *JWTKey {
+	m := s.load()
+	kid := m.current(time.Now())
+	for _, k := range m.Keys {
+		if k.Kid == kid {
+			return k
+		}
+	}
+	return nil
+}
+
+// AcceptsLegacy tells whether hmac tokens still verify, they do until the first key
+// is promoted and for the window given to that generation
+func (s *JWTKeyStore) AcceptsLegacy() bool {
+	m := s.load()
+	now := time.Now()
+	if m.LegacyUntil != nil {
+		return now.Before(*m.LegacyUntil)
+	}
+	return m.current(now) == ""
+}
+
+// Lookup finds a key that may still verify tokens
+func (s *JWTKeyStore) Lookup(kid string) *JWTKey {
+	now := time.Now()
+	for _, k := range s.load().Keys {
+		if k.Kid == kid && k.usable(now) {
+			return k
+		}
+	}
+	return nil
+}
+
+// JWKS lists the public keys other services can verify tokens with
+func (s *JWTKeyStore) JWKS() map[string]any {
+	now := time.Now()
+	keys := make([]map[string]string, 0)
+	for _, k := range s.load().Keys {
+		if k.usable(now) {
+			keys = append(keys, k.JWK())
+		}
+	}
+	return map[string]any{"keys": keys}
+}
+
+func readJWTManifest(dir string) (*jwtManifest, error) {
+	raw, err := os.ReadFile(filepath.Join(dir, jwtKeyManifest))
+	if os.IsNotExist(err) {
+		return &jwtManifest{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &jwtManifest{}
+	if err = json.Unmarshal(raw, m); err != nil {
+		return nil, err
+	}
+	for _, k := range m.Keys {
+		pemBytes, err := os.ReadFile(filepath.Join(dir, k.Kid+".pem"))
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(pemBytes)
+		if block == nil {
+			return nil, fmt.Errorf("key %s is not pem encoded", k.Kid)
+		}
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", k.Kid, err)
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("key %s cannot sign", k.Kid)
+		}
+		k.private, k.public = private, signer.Public()
+	}
+	return m, nil
+}
+
+func writeJWTManifest(dir string, m *jwtManifest) error {
+	raw, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, jwtKeyManifest+".tmp")
+	if err = os.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, jwtKeyManifest))
+}
+
+// GenerateJWTKey adds a key of alg to dir, it is published in the jwks at once and signs
+// tokens after publish so verifiers caching the jwks learn it first. The previous current key,
+// or the hmac secret on the first generation, keeps verifying tokens for window after that
+// so tokens signed just before the rotation stay valid
+func GenerateJWTKey(dir, alg string, publish, window time.Duration) (*JWTKey, error) {
+	var private crypto.Signer
+	var err error
+	switch alg {
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	m, err := readJWTManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if m.Next != "" {
+		if m.current(now) != m.Next {
+			return nil, fmt.Errorf("key %s is still being published", m.Next)
+		}
+		m.Current, m.Next, m.PromoteAt = m.Next, "", nil
+	}
+	b := make([]byte, 4)
+	if _, err = rand.Read(b); err != nil {
+		return nil, err
+	}
+	key := &JWTKey{
+		Kid:       fmt.Sprintf("%s-%s", now.Format("20060102"), hex.EncodeToString(b)),
+		Alg:       alg,
+		CreatedAt: now,
+		private:   private,
+		public:    private.Public(),
+	}
+	err = os.WriteFile(filepath.Join(dir, key.Kid+".pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		return nil, err
+	}
+	promoteAt := now.Add(publish)
+	retireAt := promoteAt.Add(window)
+	if m.Current == "" && m.LegacyUntil == nil {
+		m.LegacyUntil = &retireAt
+	}
+	for _, k := range m.Keys {
+		if k.Kid == m.Current && k.RetireAt == nil {
+			k.RetireAt = &retireAt
+		}
+	}
+	m.Keys = append(m.Keys, key)
+	m.Next, m.PromoteAt = key.Kid, &promoteAt
+	if err = writeJWTManifest(dir, m); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// PruneJWTKeys removes the keys that are past their retirement and returns their kids
+func PruneJWTKeys(dir string) ([]string, error) {
+	m, err := readJWTManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var kept []*JWTKey
+	var pruned []string
+	for _, k := range m.Keys {
+		if k.usable(now) || k.Kid == m.current(now) {
+			kept = append(kept, k)
+			continue
+		}
+		pruned = append(pruned, k.Kid)
+	}
+	if len(pruned) == 0 {
+		return nil, nil
+	}
+	m.Keys = kept
+	if err = writeJWTManifest(dir, m); err != nil {
+		return nil, err
+	}
+	for _, kid := range pruned {
+		if err = os.Remove(filepath.Join(dir, kid+".pem")); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return pruned, err
+		}
+	}
+	return pruned, nil
+}
+
+// ListJWTKeys returns the keys of dir, the kid of the current one and the kid of the
+// key waiting to take over with the time it does
+func ListJWTKeys(dir string) ([]*JWTKey, string, string, *time.Time, error) {
+	m, err := readJWTManifest(dir)
+	if err != nil {
+		return nil, "", "", nil, err
+	}
+	current := m.current(time.Now())
+	if current == m.Next {
+		return m.Keys, current, "", nil, nil
+	}
+	return m.Keys, current, m.Next, m.PromoteAt, nil
+}
+
+// Dir is where the keys command manages keys
+func (s *JWTKeyStore) Dir() string {
+	return s.dir
+}
@@ -0,0 +1,128 @@
+package di
+
+import (
+	"github.com/golang-jwt/jwt/v4"
+	"testing"
+	"time"
+)
+
+func TestJWTKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	first, err := GenerateJWTKey(dir, AlgRS256, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewJWTKeyStore(dir)
+	signed, err := signWith(store.Current())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := GenerateJWTKey(dir, AlgEdDSA, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store = NewJWTKeyStore(dir)
+	if store.Current().Kid != second.Kid {
+		t.Fatalf("expected %s to be current, got %s", second.Kid, store.Current().Kid)
+	}
+	// the previous key still verifies during the window
+	if _, err = verifyWith(store, signed); err != nil {
+		t.Fatalf("token of the previous key rejected: %s", err)
+	}
+	if n := len(store.JWKS()["keys"].([]map[string]string)); n != 2 {
+		t.Fatalf("expected 2 keys in jwks, got %d", n)
+	}
+	again, err := signWith(store.Current())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = verifyWith(store, again); err != nil {
+		t.Fatalf("token of the current key rejected: %s", err)
+	}
+
+	// a third rotation with no window retires the second key at once
+	if _, err = GenerateJWTKey(dir, AlgEdDSA, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	pruned, err := PruneJWTKeys(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 1 || pruned[0] != second.Kid {
+		t.Fatalf("expected %s to be pruned, got %v", second.Kid, pruned)
+	}
+	store = NewJWTKeyStore(dir)
+	if store.Lookup(first.Kid) == nil {
+		t.Fatal("first key should verify until its retirement")
+	}
+	if _, err = verifyWith(store, again); err == nil {
+		t.Fatal("token of a pruned key accepted")
+	}
+}
+
+func TestJWTKeyLegacyWindow(t *testing.T) {
+	dir := t.TempDir()
+	if !NewJWTKeyStore(dir).AcceptsLegacy() {
+		t.Fatal("hmac tokens rejected before the first key")
+	}
+	if _, err := GenerateJWTKey(dir, AlgEdDSA, 0, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if !NewJWTKeyStore(dir).AcceptsLegacy() {
+		t.Fatal("hmac tokens rejected during the window of the first key")
+	}
+	// later rotations leave the end of the legacy window alone
+	if _, err := GenerateJWTKey(dir, AlgEdDSA, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !NewJWTKeyStore(dir).AcceptsLegacy() {
+		t.Fatal("a rotation moved the legacy window")
+	}
+
+	dir = t.TempDir()
+	if _, err := GenerateJWTKey(dir, AlgEdDSA, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if NewJWTKeyStore(dir).AcceptsLegacy() {
+		t.Fatal("hmac tokens accepted after the window")
+	}
+}
+
+func TestJWTKeyPublishDelay(t *testing.T) {
+	dir := t.TempDir()
+	first, err := GenerateJWTKey(dir, AlgEdDSA, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := GenerateJWTKey(dir, AlgEdDSA, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewJWTKeyStore(dir)
+	if store.Current().Kid != first.Kid {
+		t.Fatalf("expected %s to sign until the new key is published, got %s", first.Kid, store.Current().Kid)
+	}
+	if store.Lookup(second.Kid) == nil {
+		t.Fatal("the new key is not published")
+	}
+	if _, err = GenerateJWTKey(dir, AlgEdDSA, 0, time.Hour); err == nil {
+		t.Fatal("a rotation started while another key was being published")
+	}
+}
+
+func signWith(key *JWTKey) (string, error) {
+	token := jwt.NewWithClaims(key.Method(), jwt.MapClaims{"uid": 1})
+	token.Header["kid"] = key.Kid
+	return token.SignedString(key.Private())
+}
+
+func verifyWith(store *JWTKeyStore, raw string) (*jwt.Token, error) {
+	return jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		key := store.Lookup(token.Header["kid"].(string))
+		if key == nil || key.Alg != token.Method.Alg() {
+			return nil, jwt.ErrInvalidKey
+		}
+		return key.Public(), nil
+	})
+}
@@ -0,0 +1,64 @@
+package di
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mix-go/xdi"
+	"github.com/mix-go/xutil/xenv"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+func init() {
+	obj := xdi.Object{
+		Name: "mailer",
+		New: func() (i interface{}, e error) {
+			return &SMTPMailer{
+				Addr:     xenv.Getenv("SMTP_ADDR").String(),
+				Username: xenv.Getenv("SMTP_USERNAME").String(),
+				Password: xenv.Getenv("SMTP_PASSWORD").String(),
+				From:     xenv.Getenv("SMTP_FROM").String(),
+			}, nil
+		},
+	}
+	if err := xdi.Provide(&obj); err != nil {
+		panic(err)
+	}
+}
+
+func Mailer() (m *SMTPMailer) {
+	if err := xdi.Populate("mailer", &m); err != nil {
+		panic(err)
+	}
+	return
+}
+
+// SMTPMailer sends plain text mails, it authenticates only when Username is set
+type SMTPMailer struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	if m.Addr == "" {
+		return errors.New("smtp is not configured")
+	}
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return errors.New("invalid mail header")
+	}
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s",
+		m.From, to, subject, time.Now().Format(time.RFC1123Z), body)
+	return smtp.SendMail(m.Addr, auth, m.From, []string{to}, []byte(msg))
+}
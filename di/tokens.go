@@ -0,0 +1,261 @@
+package di
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mix-go/xutil/xenv"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrRefreshInvalid = errors.New("invalid refresh token")
+	ErrRefreshReused  = errors.New("refresh token reused")
+)
+
+func tokenVersionKey(uid uint) string {
+	return fmt.Sprintf("tokens:version:%d", uid)
+}
+
+func tokenDenyKey(jti string) string {
+	return "tokens:deny:" + jti
+}
+
+// TokenVersion is carried in the ver claim, tokens of an older version are rejected
+func TokenVersion(ctx context.Context, uid uint) (int64, error) {
+	ver, err := GoRedis().Get(ctx, tokenVersionKey(uid)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return ver, err
+}
+
+// RevokeUserTokens invalidates every access and refresh token issued to uid so far
+func RevokeUserTokens(ctx context.Context, uid uint) error {
+	rdb := GoRedis()
+	if err := rdb.Incr(ctx, tokenVersionKey(uid)).Err(); err != nil {
+		return err
+	}
+	families, err := rdb.SMembers(ctx, refreshUserKey(uid)).Result()
+	if err != nil {
+		return err
+	}
+	keys := []string{refreshUserKey(uid)}
+	for _, family := range families {
+		keys = append(keys, refreshFamilyKey(family))
+	}
+	return rdb.Del(ctx, keys...).Err()
+}
+
+// DenyToken rejects the token jti until it expires by itself
+func DenyToken(ctx context.Context, jti string, exp time.Time) error {
+	ttl := time.Until(exp)
+	if ttl <= 0 {
+		return nil
+	}
+	return GoRedis().Set(ctx, tokenDenyKey(jti), 1, ttl).Err()
+}
+
+// TokenRevoked reports whether the token jti of uid at version ver has been revoked
+func TokenRevoked(ctx context.Context, uid uint, jti string, ver int64) (bool, error) {
+	rdb := GoRedis()
+	pipe := rdb.Pipeline()
+	current := pipe.Get(ctx, tokenVersionKey(uid))
+	denied := pipe.Exists(ctx, tokenDenyKey(jti))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return false, err
+	}
+	if current.Err() == nil {
+		if v, err := current.Int64(); err == nil && ver < v {
+			return true, nil
+		}
+	}
+	return jti != "" && denied.Val() > 0, nil
+}
+
+// RevokeRefreshToken ends the family of token, used on logout
+func RevokeRefreshToken(ctx context.Context, token string) error {
+	family, err := GoRedis().HGet(ctx, refreshTokenKey(token), "family").Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return GoRedis().Del(ctx, refreshFamilyKey(family)).Err()
+}
+
+// RefreshTokenTTL is how long a refresh token can be used, every rotation extends its family
+func RefreshTokenTTL() time.Duration {
+	return time.Duration(xenv.Getenv("REFRESH_TOKEN_TTL").Int64(30*24*3600)) * time.Second
+}
+
+// Refresh tokens are opaque and only their hash is stored. Every login starts a family,
+// a rotation marks the token used and issues the next one of the same family, presenting
+// a used token again means it leaked and the whole family is revoked.
+
+func refreshTokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "refresh:token:" + hex.EncodeToString(sum[:])
+}
+
+func refreshFamilyKey(family string) string {
+	return "refresh:family:" + family
+}
+
+func refreshUserKey(uid uint) string {
+	return fmt.Sprintf("refresh:user:%d", uid)
+}
+
+// IssueRefreshToken starts a new token family for uid
+func IssueRefreshToken(ctx context.Context, uid uint) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	family := hex.EncodeToString(b)
+	token, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	ttl := RefreshTokenTTL()
+	_, err = GoRedis().TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, refreshFamilyKey(family), uid, ttl)
+		p.SAdd(ctx, refreshUserKey(uid), family)
+		p.Expire(ctx, refreshUserKey(uid), ttl)
+		p.HSet(ctx, refreshTokenKey(token), "uid", uid, "family", family, "used", 0)
+		p.Expire(ctx, refreshTokenKey(token), ttl)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RotateRefreshToken exchanges token for the next one of its family and returns its user
+func RotateRefreshToken(ctx context.Context, token string) (uint, string, error) {
+	rdb := GoRedis()
+	key := refreshTokenKey(token)
+	var uid uint
+	var next string
+	err := rdb.Watch(ctx, func(tx *redis.Tx) error {
+		vals, err := tx.HGetAll(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if len(vals) == 0 {
+			return ErrRefreshInvalid
+		}
+		family := vals["family"]
+		alive, err := tx.Exists(ctx, refreshFamilyKey(family)).Result()
+		if err != nil {
+			return err
+		}
+		if alive == 0 {
+			return ErrRefreshInvalid
+		}
+		id, err := strconv.ParseUint(vals["uid"], 10, 0)
+		if err != nil {
+			return ErrRefreshInvalid
+		}
+		uid = uint(id)
+		if vals["used"] != "0" {
+			if err = tx.Del(ctx, refreshFamilyKey(family)).Err(); err != nil {
+				return err
+			}
+			return ErrRefreshReused
+		}
+
+		if next, err = newRefreshToken(); err != nil {
+			return err
+		}
+		ttl := RefreshTokenTTL()
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			// the used token stays around to detect its reuse
+			p.HSet(ctx, key, "used", 1)
+			p.HSet(ctx, refreshTokenKey(next), "uid", uid, "family", family, "used", 0)
+			p.Expire(ctx, refreshTokenKey(next), ttl)
+			p.Expire(ctx, refreshFamilyKey(family), ttl)
+			p.Expire(ctx, refreshUserKey(uid), ttl)
+			return nil
+		})
+		return err
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		// the token was rotated concurrently, the other request keeps the family
+		return 0, "", ErrRefreshInvalid
+	}
+	if err != nil {
+		return uid, "", err
+	}
+	return uid, next, nil
+}
+
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Stream tickets stand in for the access token on the event and draft streams, whose clients
+// can only authenticate in the url. A ticket is opaque, works once and only for StreamTicketTTL,
+// so a url leaking into access logs gives nothing away.
+
+// StreamTicketTTL is how long a ticket waits for its connection
+const StreamTicketTTL = 30 * time.Second
+
+// StreamTicket is what a ticket stands for
+type StreamTicket struct {
+	UserID    uint      `json:"uid"`
+	Roles     []string  `json:"roles,omitempty"`
+	TokenID   string    `json:"jti,omitempty"`
+	Version   int64     `json:"ver"`
+	ExpiresAt time.Time `json:"exp"`
+}
+
+func streamTicketKey(ticket string) string {
+	sum := sha256.Sum256([]byte(ticket))
+	return "stream:ticket:" + hex.EncodeToString(sum[:])
+}
+
+// IssueStreamTicket returns a ticket for t
+func IssueStreamTicket(ctx context.Context, t StreamTicket) (string, error) {
+	ticket, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	raw, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	if err = GoRedis().Set(ctx, streamTicketKey(ticket), raw, StreamTicketTTL).Err(); err != nil {
+		return "", err
+	}
+	return ticket, nil
+}
+
+// RedeemStreamTicket uses up ticket, it returns nil for unknown, used and expired tickets
+func RedeemStreamTicket(ctx context.Context, ticket string) (*StreamTicket, error) {
+	raw, err := GoRedis().GetDel(ctx, streamTicketKey(ticket)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	t := &StreamTicket{}
+	if err = json.Unmarshal(raw, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
@@ -10,44 +11,80 @@ import (
 	"strings"
 )
 
+// VerificationKey picks the key a token is checked with, tokens with a kid are verified
+// with the matching public key, tokens without one are legacy HMAC tokens
+func VerificationKey(token *jwt.Token) (interface{}, error) {
+	if kid, ok := token.Header["kid"].(string); ok {
+		key := di.JWTKeys().Lookup(kid)
+		if key == nil {
+			return nil, fmt.Errorf("unknown key %s", kid)
+		}
+		// Don't forget to validate the alg is what you expect:
+		if token.Method.Alg() != key.Alg {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.Public(), nil
+	}
+
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	secret := xenv.Getenv("HMAC_SECRET").String()
+	if secret == "" || !di.JWTKeys().AcceptsLegacy() {
+		return nil, errors.New("hmac tokens are not accepted")
+	}
+	return []byte(secret), nil
+}
+
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 获取 token
 		tokenString := c.GetHeader("Authorization")
 		if strings.Index(tokenString, "Bearer ") != 0 {
-			di.Zap().Error("failed to get token while parsing tokenString")
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"status":  http.StatusInternalServerError,
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"status":  http.StatusUnauthorized,
 				"message": "failed to extract token",
 			})
 			c.Abort()
 			return
 		}
 
-		// 解码
-		token, err := jwt.Parse(tokenString[7:], func(token *jwt.Token) (interface{}, error) {
-			// Don't forget to validate the alg is what you expect:
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
+		// 解码并校验 iss/aud/exp
+		claims, err := ParseToken(tokenString[7:])
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"status":  http.StatusUnauthorized,
+				"message": err.Error(),
+			})
+			c.Abort()
+			return
+		}
 
-			// hmacSampleSecret is a []byte containing your secret, e.g. []byte("my_secret_key")
-			return []byte(xenv.Getenv("HMAC_SECRET").String()), nil
-		})
+		revoked, err := di.TokenRevoked(c.Request.Context(), claims.UserID, claims.ID, claims.Version)
 		if err != nil {
-			di.Zap().Error("failed to parse token")
+			di.Zap().Errorf("failed to check token revocation: %s", err)
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"status":  http.StatusInternalServerError,
-				"message": err.Error(),
+				"message": "internal server error",
+			})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"status":  http.StatusUnauthorized,
+				"message": "token has been revoked",
 			})
 			c.Abort()
 			return
 		}
 
 		// 保存信息
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			c.Set("payload", claims)
+		principal := &Principal{UserID: claims.UserID, Roles: claims.Roles, TokenID: claims.ID, Version: claims.Version}
+		if claims.ExpiresAt != nil {
+			principal.ExpiresAt = claims.ExpiresAt.Time
 		}
+		c.Set(principalKey, principal)
 
 		c.Next()
 	}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/mix-go/xutil/xenv"
+	"hammer-web-api/di"
+	"net/http"
+	"time"
+)
+
+const principalKey = "principal"
+
+// Claims are the claims of an access token, jti is RegisteredClaims.ID
+type Claims struct {
+	jwt.RegisteredClaims
+	UserID  uint     `json:"uid"`
+	Roles   []string `json:"roles,omitempty"`
+	Version int64    `json:"ver"`
+}
+
+// Valid checks the time based claims, the issuer and the audience. Tokens issued before
+// the audience claim existed are rejected, their clients get a new one from auth/refresh
+func (c *Claims) Valid() error {
+	if err := c.RegisteredClaims.Valid(); err != nil {
+		return err
+	}
+	if !c.VerifyIssuer(TokenIssuer(), true) {
+		return errors.New("token has an unexpected issuer")
+	}
+	if !c.VerifyAudience(TokenAudience(), true) {
+		return errors.New("token is not meant for this api")
+	}
+	if c.UserID == 0 {
+		return errors.New("token has no user")
+	}
+	return nil
+}
+
+func TokenIssuer() string {
+	return xenv.Getenv("JWT_ISSUER").String("http://hammer.wang")
+}
+
+func TokenAudience() string {
+	return xenv.Getenv("JWT_AUDIENCE").String("hammer-web-api")
+}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID    uint
+	Roles     []string
+	TokenID   string
+	Version   int64
+	ExpiresAt time.Time
+}
+
+// Active tells whether the token of p has neither expired nor been revoked since the
+// request was authenticated, long lived streams check it on every heartbeat
+func (p *Principal) Active(ctx context.Context) (bool, error) {
+	if !p.ExpiresAt.IsZero() && !time.Now().Before(p.ExpiresAt) {
+		return false, nil
+	}
+	revoked, err := di.TokenRevoked(ctx, p.UserID, p.TokenID, p.Version)
+	if err != nil {
+		return false, err
+	}
+	return !revoked, nil
+}
+
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireRole lets only principals with role through, it must run after AuthMiddleware
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if p, ok := CurrentPrincipal(c); !ok || !p.HasRole(role) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"status":  http.StatusForbidden,
+				"message": "request no permission",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// ParseToken verifies a token and returns its claims
+func ParseToken(raw string) (*Claims, error) {
+	claims := &Claims{}
+	if _, err := jwt.ParseWithClaims(raw, claims, VerificationKey); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// CurrentPrincipal returns the principal AuthMiddleware stored for the request
+func CurrentPrincipal(c *gin.Context) (*Principal, bool) {
+	v, ok := c.Get(principalKey)
+	if !ok {
+		return nil, false
+	}
+	p, ok := v.(*Principal)
+	return p, ok
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClaimsValid(t *testing.T) {
+	now := time.Now()
+	valid := func() *Claims {
+		return &Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    TokenIssuer(),
+				Audience:  jwt.ClaimStrings{TokenAudience()},
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+			},
+			UserID: 1,
+		}
+	}
+	if err := valid().Valid(); err != nil {
+		t.Fatalf("valid claims rejected: %s", err)
+	}
+
+	cases := map[string]func(*Claims){
+		"expired":     func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute)) },
+		"issuer":      func(c *Claims) { c.Issuer = "http://example.com" },
+		"no audience": func(c *Claims) { c.Audience = nil },
+		"other api":   func(c *Claims) { c.Audience = jwt.ClaimStrings{"other-api"} },
+		"no user":     func(c *Claims) { c.UserID = 0 },
+	}
+	for name, mutate := range cases {
+		claims := valid()
+		mutate(claims)
+		if claims.Valid() == nil {
+			t.Errorf("%s: claims accepted", name)
+		}
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := map[string]struct {
+		principal *Principal
+		want      int
+	}{
+		"anonymous": {nil, http.StatusForbidden},
+		"user":      {&Principal{UserID: 1}, http.StatusForbidden},
+		"admin":     {&Principal{UserID: 1, Roles: []string{"admin"}}, http.StatusOK},
+	}
+	for name, tc := range cases {
+		w := httptest.NewRecorder()
+		c, r := gin.CreateTestContext(w)
+		r.GET("/", func(c *gin.Context) {
+			if tc.principal != nil {
+				c.Set(principalKey, tc.principal)
+			}
+		}, RequireRole("admin"), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		r.HandleContext(c)
+		if w.Code != tc.want {
+			t.Errorf("%s: got %d, want %d", name, w.Code, tc.want)
+		}
+	}
+}
+
+func TestPrincipalActiveExpired(t *testing.T) {
+	p := &Principal{UserID: 1, ExpiresAt: time.Now().Add(-time.Second)}
+	if active, err := p.Active(context.Background()); err != nil || active {
+		t.Errorf("expired principal: active = %v, err = %v", active, err)
+	}
+}
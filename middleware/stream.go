@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"hammer-web-api/di"
+	"net/http"
+)
+
+// StreamAuth authenticates the stream routes. Clients that cannot set headers, like EventSource
+// and browser websockets, pass a ticket from POST events/ticket as ?ticket= instead of their token,
+// everyone else goes through AuthMiddleware
+func StreamAuth() gin.HandlerFunc {
+	auth := AuthMiddleware()
+	return func(c *gin.Context) {
+		ticket := c.Query("ticket")
+		if ticket == "" || c.GetHeader("Authorization") != "" {
+			auth(c)
+			return
+		}
+
+		ctx := c.Request.Context()
+		t, err := di.RedeemStreamTicket(ctx, ticket)
+		revoked := false
+		if err == nil && t != nil {
+			revoked, err = di.TokenRevoked(ctx, t.UserID, t.TokenID, t.Version)
+		}
+		if err != nil {
+			di.Zap().Errorf("failed to redeem stream ticket: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status":  http.StatusInternalServerError,
+				"message": "internal server error",
+			})
+			c.Abort()
+			return
+		}
+		if t == nil || revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"status":  http.StatusUnauthorized,
+				"message": "invalid or expired ticket",
+			})
+			c.Abort()
+			return
+		}
+
+		// the stream lives as long as the access token the ticket was issued for
+		c.Set(principalKey, &Principal{UserID: t.UserID, Roles: t.Roles, TokenID: t.TokenID, Version: t.Version, ExpiresAt: t.ExpiresAt})
+
+		c.Next()
+	}
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+// TextbookBlob is textbook content addressed by the sha256 of its full text,
+// it is stored either as a full snapshot or as a delta against the blob BaseHash
+type TextbookBlob struct {
+	Hash      string  `gorm:"type:char(64);primaryKey"`
+	BaseHash  *string `gorm:"type:char(64);null;comment: delta的基准blob,完整快照为空"`
+	Depth     uint    `gorm:"type:int unsigned;not null;default:0;comment: 距离最近完整快照的delta数"`
+	Size      uint    `gorm:"type:int unsigned;not null;comment: 还原后的正文字节数"`
+	Data      []byte  `gorm:"type:mediumblob;not null"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+type TextbookDraft struct {
+	gorm.Model
+	Content  string `gorm:"type:mediumtext;not null;comment: 协同编辑中的草稿正文" json:"content"`
+	Revision uint   `gorm:"type:int unsigned;not null;comment: 草稿的操作序号" json:"revision"`
+
+	TextbookID    uint     `gorm:"type:int unsigned;not null;uniqueIndex" json:"textbookID"`
+	Textbook      Textbook `json:"-"`
+	BaseVersionID uint     `gorm:"type:int unsigned;not null;comment: 草稿所基于的版本" json:"baseVersionID"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+	ExportExpired = "expired"
+)
+
+// DataExport is a zip of everything a user owns, built in the background
+type DataExport struct {
+	gorm.Model
+	Status   string `gorm:"type:varchar(20);not null;default:pending;index;comment: pending,running,ready,failed,expired" json:"status"`
+	FileName string `gorm:"type:varchar(255);null;comment: 导出目录下的文件名" json:"-"`
+	Size     int64  `gorm:"not null;default:0" json:"size"`
+	Error    string `gorm:"type:varchar(255);null" json:"-"`
+
+	UserID uint `gorm:"type:int unsigned;not null;index" json:"-"`
+	User   User `json:"-"`
+
+	LeaseUntil  *time.Time `gorm:"null;comment: 处理中的导出在此之前不会被其他实例领取" json:"-"`
+	CompletedAt *time.Time `gorm:"null" json:"completedAt,omitempty"`
+	ExpiresAt   *time.Time `gorm:"null;index" json:"expiresAt,omitempty"`
+}
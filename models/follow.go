@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	ActivityTextbook     = "textbook"
+	ActivityVersion      = "version"
+	ActivityLearningPath = "learning_path"
+)
+
+type Follow struct {
+	ID         uint      `gorm:"primarykey" json:"-"`
+	FollowerID uint      `gorm:"type:int unsigned;not null;uniqueIndex:idx_follower_id_followee_id" json:"followerID"`
+	Follower   User      `gorm:"foreignKey:FollowerID" json:"-"`
+	FolloweeID uint      `gorm:"type:int unsigned;not null;uniqueIndex:idx_follower_id_followee_id;index" json:"followeeID"`
+	Followee   User      `gorm:"foreignKey:FolloweeID" json:"-"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// Activity is an entry of the feed, ids grow with time so they double as feed cursors
+type Activity struct {
+	ID    uint   `gorm:"primarykey" json:"id"`
+	Kind  string `gorm:"type:varchar(20);not null;comment: textbook,version,learning_path" json:"kind"`
+	Title string `gorm:"type:varchar(255);not null" json:"title"`
+
+	ActorID uint `gorm:"type:int unsigned;not null;index" json:"actorID"`
+	Actor   User `gorm:"foreignKey:ActorID" json:"-"`
+
+	TextbookID *uint  `gorm:"type:int unsigned;null" json:"textbookID,omitempty"`
+	VersionID  *uint  `gorm:"type:int unsigned;null" json:"versionID,omitempty"`
+	Version    string `gorm:"type:varchar(20);null" json:"version,omitempty"`
+	PathID     *uint  `gorm:"type:int unsigned;null" json:"pathID,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+type LearningPath struct {
+	gorm.Model
+	Title     string `gorm:"type:varchar(100);not null;comment: 学习路线名" json:"title,omitempty"`
+	Desc      string `gorm:"type:varchar(255);null;comment: 学习路线描述" json:"desc,omitempty"`
+	IsPrivate bool   `gorm:"not null;default:false" json:"isPrivate,omitempty"`
+
+	OwnerID uint `gorm:"type:int unsigned;not null;index" json:"ownerID,omitempty"`
+	Owner   User `gorm:"foreignKey:OwnerID" json:"-"`
+
+	Steps []LearningPathStep `gorm:"foreignKey:PathID" json:"steps,omitempty"`
+}
+
+type LearningPathStep struct {
+	ID       uint   `gorm:"primarykey" json:"-"`
+	Position uint   `gorm:"type:int unsigned;not null;comment: 步骤序号,从0开始" json:"position"`
+	Note     string `gorm:"type:text;null;comment: 进入该步骤前的说明" json:"note,omitempty"`
+
+	PathID uint `gorm:"type:int unsigned;not null;index" json:"-"`
+
+	TextbookID uint     `gorm:"type:int unsigned;not null" json:"textbookID"`
+	Textbook   Textbook `json:"-"`
+}
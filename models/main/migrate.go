@@ -19,8 +19,35 @@ func main() {
 	}
 
 	//err = db.Table("users").AutoMigrate(&models.User{})
-	err = db.AutoMigrate(&models.Textbook{}, &models.TextbookVersion{}, &models.UserOperation{})
+	err = db.AutoMigrate(&models.Textbook{}, &models.TextbookVersion{}, &models.UserOperation{},
+		&models.Proposal{}, &models.ProposalComment{}, &models.TextbookBlob{},
+		&models.LearningPath{}, &models.LearningPathStep{}, &models.ReadingProgress{},
+		&models.TextbookPrerequisite{},
+		&models.Quiz{}, &models.QuizQuestion{}, &models.QuizAttempt{}, &models.QuizAnswer{},
+		&models.Notification{}, &models.Webhook{}, &models.WebhookDelivery{},
+		&models.TextbookDraft{}, &models.Follow{}, &models.Activity{}, &models.DataExport{})
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	// the users table predates the migrations, columns added since are created one by one
+	err = addUserColumns(db, "Roles", "DeletionScheduledAt", "DeletionPolicy", "DeletionTransferToID", "AnonymizedAt")
+	if err == nil && !db.Migrator().HasIndex(&models.User{}, "DeletionScheduledAt") {
+		err = db.Migrator().CreateIndex(&models.User{}, "DeletionScheduledAt")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func addUserColumns(db *gorm.DB, fields ...string) error {
+	for _, field := range fields {
+		if db.Migrator().HasColumn(&models.User{}, field) {
+			continue
+		}
+		if err := db.Migrator().AddColumn(&models.User{}, field); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	NotifyNewVersion   = "version"
+	NotifyCommentReply = "reply"
+	NotifyInvitation   = "invitation"
+	NotifyExportReady  = "export"
+)
+
+type Notification struct {
+	gorm.Model
+	Kind    string `gorm:"type:varchar(20);not null;comment: version,reply,invitation,export" json:"kind"`
+	Message string `gorm:"type:varchar(255);not null" json:"message"`
+
+	UserID uint `gorm:"type:int unsigned;not null;index:idx_user_id_read_at" json:"-"`
+	User   User `json:"-"`
+
+	ActorID    *uint `gorm:"type:int unsigned;null;comment: 触发通知的用户" json:"actorID,omitempty"`
+	TextbookID *uint `gorm:"type:int unsigned;null" json:"textbookID,omitempty"`
+	VersionID  *uint `gorm:"type:int unsigned;null" json:"versionID,omitempty"`
+	ProposalID *uint `gorm:"type:int unsigned;null" json:"proposalID,omitempty"`
+	ExportID   *uint `gorm:"type:int unsigned;null" json:"exportID,omitempty"`
+
+	ReadAt *time.Time `gorm:"null;index:idx_user_id_read_at" json:"readAt"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// TextbookPrerequisite says that Textbook should be read after Required
+type TextbookPrerequisite struct {
+	ID         uint      `gorm:"primarykey" json:"-"`
+	TextbookID uint      `gorm:"type:int unsigned;not null;uniqueIndex:idx_textbook_id_required_id" json:"textbookID"`
+	Textbook   Textbook  `json:"-"`
+	RequiredID uint      `gorm:"type:int unsigned;not null;uniqueIndex:idx_textbook_id_required_id;index" json:"requiredID"`
+	Required   Textbook  `gorm:"foreignKey:RequiredID" json:"-"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
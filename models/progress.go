@@ -0,0 +1,18 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+type ReadingProgress struct {
+	gorm.Model
+	UserID uint `gorm:"type:int unsigned;not null;uniqueIndex:idx_user_id_textbook_id" json:"userID,omitempty"`
+	User   User `json:"-"`
+
+	TextbookID uint     `gorm:"type:int unsigned;not null;uniqueIndex:idx_user_id_textbook_id" json:"textbookID,omitempty"`
+	Textbook   Textbook `json:"-"`
+
+	Percent     uint       `gorm:"type:tinyint unsigned;not null;default:0;comment: 阅读进度0-100" json:"percent"`
+	CompletedAt *time.Time `gorm:"null;comment: 标记读完的时间" json:"completedAt,omitempty"`
+}
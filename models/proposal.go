@@ -0,0 +1,48 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+const (
+	ProposalOpen     = "open"
+	ProposalApproved = "approved"
+	ProposalRejected = "rejected"
+	ProposalMerged   = "merged"
+)
+
+type Proposal struct {
+	gorm.Model
+	Title       string `gorm:"type:varchar(100);not null;comment: 提案标题" json:"title,omitempty"`
+	Description string `gorm:"type:text;null;comment: 提案描述" json:"description,omitempty"`
+	Content     string `gorm:"type:mediumtext;not null;comment: 提议的教程正文" json:"content,omitempty"`
+	Status      string `gorm:"type:varchar(20);not null;default:open;index" json:"status,omitempty"`
+
+	TextbookID uint     `gorm:"type:int unsigned;not null;index" json:"textbookID,omitempty"`
+	Textbook   Textbook `json:"-"`
+
+	BaseVersionID uint            `gorm:"type:int unsigned;not null" json:"baseVersionID,omitempty"`
+	BaseVersion   TextbookVersion `json:"-"`
+
+	ProposerID uint `gorm:"type:int unsigned;not null;index" json:"proposerID,omitempty"`
+	Proposer   User `gorm:"foreignKey:ProposerID" json:"-"`
+
+	ReviewerID *uint `gorm:"type:int unsigned;null" json:"reviewerID,omitempty"`
+	Reviewer   User  `gorm:"foreignKey:ReviewerID" json:"-"`
+
+	MergedVersionID *uint `gorm:"type:int unsigned;null" json:"mergedVersionID,omitempty"`
+}
+
+type ProposalComment struct {
+	gorm.Model
+	Content string `gorm:"type:text;not null" json:"content,omitempty"`
+	Line    *uint  `gorm:"type:int unsigned;null;comment: 评论针对的diff行" json:"line,omitempty"`
+
+	ParentID *uint `gorm:"type:int unsigned;null;comment: 回复的评论" json:"parentID,omitempty"`
+
+	ProposalID uint     `gorm:"type:int unsigned;not null;index" json:"proposalID,omitempty"`
+	Proposal   Proposal `json:"-"`
+
+	AuthorID uint `gorm:"type:int unsigned;not null" json:"authorID,omitempty"`
+	Author   User `gorm:"foreignKey:AuthorID" json:"-"`
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"gorm.io/gorm"
+)
+
+const (
+	QuestionSingle    = "single"
+	QuestionMulti     = "multi"
+	QuestionFill      = "fill"
+	QuestionTrueFalse = "truefalse"
+)
+
+// StringList is a list of strings stored as a json array
+type StringList []string
+
+func (s StringList) Value() (driver.Value, error) {
+	if s == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(s)
+	return string(b), err
+}
+
+func (s *StringList) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*s = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	}
+	return errors.New("unsupported type for StringList")
+}
+
+type Quiz struct {
+	gorm.Model
+	Title   string `gorm:"type:varchar(100);not null;comment: 测验标题" json:"title,omitempty"`
+	Section string `gorm:"type:varchar(255);null;comment: 测验所属章节标题" json:"section,omitempty"`
+
+	TextbookVersionID uint            `gorm:"type:int unsigned;not null;index" json:"versionID,omitempty"`
+	TextbookVersion   TextbookVersion `json:"-"`
+
+	AuthorID uint `gorm:"type:int unsigned;not null" json:"authorID,omitempty"`
+	Author   User `gorm:"foreignKey:AuthorID" json:"-"`
+
+	Questions []QuizQuestion `json:"questions,omitempty"`
+}
+
+type QuizQuestion struct {
+	ID          uint       `gorm:"primarykey" json:"id"`
+	Position    uint       `gorm:"type:int unsigned;not null" json:"position"`
+	Kind        string     `gorm:"type:varchar(20);not null;comment: single,multi,fill,truefalse" json:"kind"`
+	Prompt      string     `gorm:"type:text;not null" json:"prompt"`
+	Options     StringList `gorm:"type:text" json:"options,omitempty"`
+	Answer      StringList `gorm:"type:text;not null;comment: 选项序号,true/false或可接受的填空答案" json:"-"`
+	Explanation string     `gorm:"type:text;null" json:"-"`
+
+	QuizID uint `gorm:"type:int unsigned;not null;index" json:"-"`
+}
+
+type QuizAttempt struct {
+	gorm.Model
+	Score uint `gorm:"type:int unsigned;not null" json:"score"`
+	Total uint `gorm:"type:int unsigned;not null" json:"total"`
+
+	QuizID uint `gorm:"type:int unsigned;not null;index:idx_quiz_id_user_id" json:"quizID"`
+	Quiz   Quiz `json:"-"`
+	UserID uint `gorm:"type:int unsigned;not null;index:idx_quiz_id_user_id" json:"userID"`
+	User   User `json:"-"`
+
+	Answers []QuizAnswer `gorm:"foreignKey:AttemptID" json:"answers,omitempty"`
+}
+
+type QuizAnswer struct {
+	ID      uint       `gorm:"primarykey" json:"-"`
+	Given   StringList `gorm:"type:text" json:"given"`
+	Correct bool       `gorm:"not null" json:"correct"`
+
+	AttemptID  uint `gorm:"type:int unsigned;not null;index" json:"-"`
+	QuizID     uint `gorm:"type:int unsigned;not null;index" json:"-"`
+	QuestionID uint `gorm:"type:int unsigned;not null" json:"questionID"`
+}
@@ -20,22 +20,32 @@ type Textbook struct {
 
 	IsHot bool `gorm:"not null;default:false" json:"isHot,omitempty"`
 	Mark  uint `gorm:"type:tinyint unsigned" json:"mark,omitempty"`
+
+	IsPrivate bool `gorm:"not null;default:false;comment: 私有教程不可被fork" json:"isPrivate,omitempty"`
+
+	ForkedFromID *uint     `gorm:"type:int unsigned;null;index" json:"forkedFromID,omitempty"`
+	ForkedFrom   *Textbook `gorm:"foreignKey:ForkedFromID" json:"forkedFrom,omitempty"`
+	ForkCount    uint      `gorm:"type:int unsigned;not null;default:0" json:"forkCount"`
 }
 
 type TextbookVersion struct {
 	gorm.Model
-	No      string `gorm:"type:varchar(20);not null;comment: 版本号" json:"no,omitempty"`
-	Content string `gorm:"type:mediumtext;not null;comment: 教程正文" json:"content,omitempty"`
+	No       string `gorm:"type:varchar(20);not null;comment: 版本号" json:"no,omitempty"`
+	Content  string `gorm:"type:mediumtext;not null;comment: 教程正文,存入blob后为空" json:"content,omitempty"`
+	BlobHash string `gorm:"type:char(64);null;index;comment: 正文的sha256" json:"-"`
 
 	TextbookID uint `gorm:"int unsigned;not null;index" json:"textbookID,omitempty"`
 	Textbook   Textbook
 }
 
+// ErrVersionFormat is returned when creating a version whose number is not like 1.2.3
+var ErrVersionFormat = errors.New("invalid version format")
+
 func (tv *TextbookVersion) BeforeCreate(tx *gorm.DB) error {
 	matched, _ := regexp.MatchString(`^[0-9]+\.[0-9]+\.[0-9]+$`, tv.No)
 	var err error
 	if !matched {
-		err = errors.New("invalid version format")
+		err = ErrVersionFormat
 	}
 	return err
 }
@@ -8,10 +8,25 @@ import (
 type User struct {
 	gorm.Model
 	Username string     `gorm:"type:varchar(255);unique;not null" json:"username" binding:"required"`
-	Password string     `gorm:"type:varchar(255);not null" json:"password" binding:"required"`
+	Password string     `gorm:"type:varchar(255);not null" json:"-"`
 	Phone    string     `gorm:"type:varchar(11);unique;not null;index" json:"phone" binding:"required"`
 	Email    string     `gorm:"type:varchar(255);unique;null" json:"email" binding:"omitempty,email"`
 	BirthDay *time.Time `gorm:"type:date;null" json:"birthDay" binding:"omitempty"`
 	Profile  string     `gorm:"type:text;null" json:"profile" binding:"omitempty"`
 	Avatar   string     `gorm:"type:varchar(255);null" json:"avatar" binding:"omitempty,url"`
+	Roles    StringList `gorm:"type:varchar(255);null;comment: 角色,写入access token" json:"-"`
+
+	DeletionScheduledAt  *time.Time `gorm:"null;index;comment: 账号删除生效时间" json:"deletionScheduledAt,omitempty"`
+	DeletionPolicy       string     `gorm:"type:varchar(20);null;comment: 教程处理方式 archive/transfer" json:"-"`
+	DeletionTransferToID *uint      `gorm:"type:int unsigned;null;comment: 教程转交的用户" json:"-"`
+	AnonymizedAt         *time.Time `gorm:"null;comment: 个人信息匿名化时间" json:"-"`
 }
+
+// RoleAdmin is carried in the roles claim of the access tokens of administrators
+const RoleAdmin = "admin"
+
+// textbooks of a deleted account are either archived or handed to another user
+const (
+	DeletionArchive  = "archive"
+	DeletionTransfer = "transfer"
+)
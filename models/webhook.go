@@ -0,0 +1,50 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	WebhookTextbookCreated  = "textbook.created"
+	WebhookVersionPublished = "version.published"
+	WebhookTextbookDeleted  = "textbook.deleted"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+type Webhook struct {
+	gorm.Model
+	URL    string     `gorm:"type:varchar(255);not null" json:"url"`
+	Secret string     `gorm:"type:varchar(64);not null;comment: HMAC-SHA256签名密钥" json:"-"`
+	Events StringList `gorm:"type:text;not null;comment: 订阅的事件,*表示全部" json:"events"`
+	Active bool       `gorm:"not null;default:true" json:"active"`
+
+	OwnerID uint `gorm:"type:int unsigned;not null;index" json:"ownerID"`
+	Owner   User `gorm:"foreignKey:OwnerID" json:"-"`
+
+	// nil means every textbook of the owner
+	TextbookID *uint     `gorm:"type:int unsigned;null;index" json:"textbookID,omitempty"`
+	Textbook   *Textbook `json:"-"`
+}
+
+type WebhookDelivery struct {
+	gorm.Model
+	Event   string `gorm:"type:varchar(50);not null" json:"event"`
+	Payload string `gorm:"type:mediumtext;not null" json:"payload"`
+	Status  string `gorm:"type:varchar(20);not null;default:pending;index:idx_status_next_attempt_at" json:"status"`
+
+	Attempts      uint       `gorm:"type:int unsigned;not null;default:0" json:"attempts"`
+	NextAttemptAt *time.Time `gorm:"null;index:idx_status_next_attempt_at" json:"nextAttemptAt,omitempty"`
+	DeliveredAt   *time.Time `gorm:"null" json:"deliveredAt,omitempty"`
+	ResponseCode  int        `gorm:"type:int;not null;default:0" json:"responseCode"`
+	Error         string     `gorm:"type:varchar(255);null" json:"error,omitempty"`
+
+	WebhookID    uint    `gorm:"type:int unsigned;not null;index" json:"webhookID"`
+	Webhook      Webhook `json:"-"`
+	RedeliveryOf *uint   `gorm:"type:int unsigned;null" json:"redeliveryOf,omitempty"`
+}
@@ -0,0 +1,33 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"hammer-web-api/controllers"
+	m "hammer-web-api/middleware"
+	"hammer-web-api/models"
+)
+
+func InitAuthRouter(rg *gin.RouterGroup) {
+	authRouter := rg.Group("auth")
+	{
+		authRouter.POST("/refresh", func(c *gin.Context) {
+			auth := controllers.AuthController{}
+			auth.Refresh(c)
+		})
+
+		authRouter.POST("/logout", m.AuthMiddleware(), func(c *gin.Context) {
+			auth := controllers.AuthController{}
+			auth.Logout(c)
+		})
+
+		authRouter.POST("/logout-all", m.AuthMiddleware(), func(c *gin.Context) {
+			auth := controllers.AuthController{}
+			auth.LogoutAll(c)
+		})
+
+		authRouter.POST("/revoke", m.AuthMiddleware(), m.RequireRole(models.RoleAdmin), func(c *gin.Context) {
+			auth := controllers.AuthController{}
+			auth.Revoke(c)
+		})
+	}
+}
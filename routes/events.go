@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"hammer-web-api/controllers"
+	m "hammer-web-api/middleware"
+)
+
+func InitEventRouter(rg *gin.RouterGroup) {
+	eventRouter := rg.Group("events")
+	{
+		eventRouter.POST("/ticket", m.AuthMiddleware(), func(c *gin.Context) {
+			event := controllers.EventController{}
+			event.Ticket(c)
+		})
+
+		eventRouter.GET("", m.StreamAuth(), func(c *gin.Context) {
+			event := controllers.EventController{}
+			event.Stream(c)
+		})
+
+		eventRouter.GET("/ws", m.StreamAuth(), func(c *gin.Context) {
+			event := controllers.EventController{}
+			event.WebSocket(c)
+		})
+	}
+}
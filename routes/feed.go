@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"hammer-web-api/controllers"
+	m "hammer-web-api/middleware"
+)
+
+func InitFeedRouter(rg *gin.RouterGroup) {
+	feedRouter := rg.Group("feed")
+	{
+		feedRouter.GET("", m.AuthMiddleware(), func(c *gin.Context) {
+			feed := controllers.FeedController{}
+			feed.Get(c)
+		})
+	}
+}
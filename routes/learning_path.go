@@ -0,0 +1,43 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"hammer-web-api/controllers"
+	m "hammer-web-api/middleware"
+)
+
+func InitLearningPathRouter(rg *gin.RouterGroup) {
+	pathRouter := rg.Group("learning-paths")
+	{
+		pathRouter.POST("", m.AuthMiddleware(), func(c *gin.Context) {
+			path := controllers.LearningPathController{}
+			path.Post(c)
+		})
+
+		// public listing
+		pathRouter.GET("", func(c *gin.Context) {
+			path := controllers.LearningPathController{}
+			path.GetList(c)
+		})
+
+		pathRouter.GET("/:id", m.AuthMiddleware(), func(c *gin.Context) {
+			path := controllers.LearningPathController{}
+			path.Get(c)
+		})
+
+		pathRouter.PUT("/:id", m.AuthMiddleware(), func(c *gin.Context) {
+			path := controllers.LearningPathController{}
+			path.Put(c)
+		})
+
+		pathRouter.DELETE("/:id", m.AuthMiddleware(), func(c *gin.Context) {
+			path := controllers.LearningPathController{}
+			path.Delete(c)
+		})
+
+		pathRouter.GET("/:id/progress", m.AuthMiddleware(), func(c *gin.Context) {
+			path := controllers.LearningPathController{}
+			path.GetProgress(c)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package routes
 
 import (
 	"github.com/gin-gonic/gin"
+	"hammer-web-api/controllers"
 	"hammer-web-api/middleware"
 )
 
@@ -32,8 +33,21 @@ import (
 func Load(router *gin.Engine) {
 	router.Use(gin.Recovery()) // error handle
 	router.Use(middleware.CorsMiddleware())
+	router.Static("/uploads", controllers.UploadDir())
+	router.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		auth := controllers.AuthController{}
+		auth.JWKS(c)
+	})
 
 	ApiGroup := router.Group("/api/v1")
 	InitUserRouter(ApiGroup)
+	InitAuthRouter(ApiGroup)
 	InitTextbookRouter(ApiGroup)
+	InitProposalRouter(ApiGroup)
+	InitLearningPathRouter(ApiGroup)
+	InitQuizRouter(ApiGroup)
+	InitNotificationRouter(ApiGroup)
+	InitWebhookRouter(ApiGroup)
+	InitEventRouter(ApiGroup)
+	InitFeedRouter(ApiGroup)
 }
@@ -0,0 +1,32 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"hammer-web-api/controllers"
+	m "hammer-web-api/middleware"
+)
+
+func InitNotificationRouter(rg *gin.RouterGroup) {
+	notificationRouter := rg.Group("notifications")
+	{
+		notificationRouter.GET("", m.AuthMiddleware(), func(c *gin.Context) {
+			notification := controllers.NotificationController{}
+			notification.GetList(c)
+		})
+
+		notificationRouter.GET("/unread-count", m.AuthMiddleware(), func(c *gin.Context) {
+			notification := controllers.NotificationController{}
+			notification.GetUnreadCount(c)
+		})
+
+		notificationRouter.PUT("/read", m.AuthMiddleware(), func(c *gin.Context) {
+			notification := controllers.NotificationController{}
+			notification.PutReadAll(c)
+		})
+
+		notificationRouter.PUT("/:id/read", m.AuthMiddleware(), func(c *gin.Context) {
+			notification := controllers.NotificationController{}
+			notification.PutRead(c)
+		})
+	}
+}
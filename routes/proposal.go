@@ -0,0 +1,42 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"hammer-web-api/controllers"
+	m "hammer-web-api/middleware"
+)
+
+func InitProposalRouter(rg *gin.RouterGroup) {
+	proposalRouter := rg.Group("textbooks/:id/proposals")
+	{
+		proposalRouter.POST("", m.AuthMiddleware(), func(c *gin.Context) {
+			proposal := controllers.ProposalController{}
+			proposal.Post(c)
+		})
+
+		proposalRouter.GET("", m.AuthMiddleware(), func(c *gin.Context) {
+			proposal := controllers.ProposalController{}
+			proposal.GetList(c)
+		})
+
+		proposalRouter.GET("/:pid", m.AuthMiddleware(), func(c *gin.Context) {
+			proposal := controllers.ProposalController{}
+			proposal.Get(c)
+		})
+
+		proposalRouter.POST("/:pid/comments", m.AuthMiddleware(), func(c *gin.Context) {
+			proposal := controllers.ProposalController{}
+			proposal.PostComment(c)
+		})
+
+		proposalRouter.POST("/:pid/review", m.AuthMiddleware(), func(c *gin.Context) {
+			proposal := controllers.ProposalController{}
+			proposal.Review(c)
+		})
+
+		proposalRouter.POST("/:pid/merge", m.AuthMiddleware(), func(c *gin.Context) {
+			proposal := controllers.ProposalController{}
+			proposal.Merge(c)
+		})
+	}
+}
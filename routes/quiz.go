@@ -0,0 +1,45 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"hammer-web-api/controllers"
+	m "hammer-web-api/middleware"
+)
+
+func InitQuizRouter(rg *gin.RouterGroup) {
+	versionRouter := rg.Group("textbooks/:id/versions/:vid/quizzes")
+	{
+		versionRouter.POST("", m.AuthMiddleware(), func(c *gin.Context) {
+			quiz := controllers.QuizController{}
+			quiz.Post(c)
+		})
+
+		versionRouter.GET("", m.AuthMiddleware(), func(c *gin.Context) {
+			quiz := controllers.QuizController{}
+			quiz.GetList(c)
+		})
+	}
+
+	quizRouter := rg.Group("quizzes")
+	{
+		quizRouter.GET("/:qid", m.AuthMiddleware(), func(c *gin.Context) {
+			quiz := controllers.QuizController{}
+			quiz.Get(c)
+		})
+
+		quizRouter.POST("/:qid/attempts", m.AuthMiddleware(), func(c *gin.Context) {
+			quiz := controllers.QuizController{}
+			quiz.PostAttempt(c)
+		})
+
+		quizRouter.GET("/:qid/attempts", m.AuthMiddleware(), func(c *gin.Context) {
+			quiz := controllers.QuizController{}
+			quiz.GetAttempts(c)
+		})
+
+		quizRouter.GET("/:qid/stats", m.AuthMiddleware(), func(c *gin.Context) {
+			quiz := controllers.QuizController{}
+			quiz.GetStats(c)
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"hammer-web-api/controllers"
 	m "hammer-web-api/middleware"
+	"hammer-web-api/models"
 	"time"
 )
 
@@ -27,6 +28,11 @@ func InitTextbookRouter(rg *gin.RouterGroup) {
 			TextbookCtl.GetUserWorkContent(c)
 		})
 
+		textbookRouter.GET("/:id/versions/:vid", m.AuthMiddleware(), func(c *gin.Context) {
+			TextbookCtl := controllers.TextbookController{}
+			TextbookCtl.GetVersion(c)
+		})
+
 		textbookRouter.PUT("/:id", m.AuthMiddleware(), func(c *gin.Context) {
 			TextbookCtl := controllers.TextbookController{}
 			TextbookCtl.Put(c)
@@ -37,6 +43,61 @@ func InitTextbookRouter(rg *gin.RouterGroup) {
 			TextbookCtl.Delete(c)
 		})
 
+		textbookRouter.POST("/:id/fork", m.AuthMiddleware(), func(c *gin.Context) {
+			TextbookCtl := controllers.TextbookController{}
+			TextbookCtl.Fork(c)
+		})
+
+		textbookRouter.GET("/:id/forks", m.AuthMiddleware(), func(c *gin.Context) {
+			TextbookCtl := controllers.TextbookController{}
+			TextbookCtl.GetForks(c)
+		})
+
+		textbookRouter.PUT("/:id/progress", m.AuthMiddleware(), func(c *gin.Context) {
+			TextbookCtl := controllers.TextbookController{}
+			TextbookCtl.PutProgress(c)
+		})
+
+		textbookRouter.PUT("/:id/collaborator", m.AuthMiddleware(), func(c *gin.Context) {
+			TextbookCtl := controllers.TextbookController{}
+			TextbookCtl.PutCollaborator(c)
+		})
+
+		textbookRouter.GET("/:id/draft", m.AuthMiddleware(), func(c *gin.Context) {
+			TextbookCtl := controllers.TextbookController{}
+			TextbookCtl.GetDraft(c)
+		})
+
+		textbookRouter.GET("/:id/draft/ws", m.StreamAuth(), func(c *gin.Context) {
+			TextbookCtl := controllers.TextbookController{}
+			TextbookCtl.DraftSocket(c)
+		})
+
+		textbookRouter.POST("/:id/draft/commit", m.AuthMiddleware(), func(c *gin.Context) {
+			TextbookCtl := controllers.TextbookController{}
+			TextbookCtl.CommitDraft(c)
+		})
+
+		textbookRouter.GET("/:id/prerequisites", m.AuthMiddleware(), func(c *gin.Context) {
+			TextbookCtl := controllers.TextbookController{}
+			TextbookCtl.GetPrerequisites(c)
+		})
+
+		textbookRouter.POST("/:id/prerequisites", m.AuthMiddleware(), func(c *gin.Context) {
+			TextbookCtl := controllers.TextbookController{}
+			TextbookCtl.PostPrerequisite(c)
+		})
+
+		textbookRouter.DELETE("/:id/prerequisites/:rid", m.AuthMiddleware(), func(c *gin.Context) {
+			TextbookCtl := controllers.TextbookController{}
+			TextbookCtl.DeletePrerequisite(c)
+		})
+
+		textbookRouter.GET("/cache/stats", m.AuthMiddleware(), m.RequireRole(models.RoleAdmin), func(c *gin.Context) {
+			TextbookCtl := controllers.TextbookController{}
+			TextbookCtl.GetCacheStats(c)
+		})
+
 		textbookRouter.GET("/subscription", m.AuthMiddleware(), func(c *gin.Context) {
 			TextbookCtl := controllers.TextbookController{}
 			TextbookCtl.GetSubscription(c)
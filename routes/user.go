@@ -31,10 +31,74 @@ func InitUserRouter(rg *gin.RouterGroup) {
 		})
 
 		// non-standard api
+		userRouter.POST("/:id/avatar", m.AuthMiddleware(), func(c *gin.Context) {
+			user := controllers.UserController{}
+			user.PostAvatar(c)
+		})
+
+		userRouter.DELETE("/:id/deletion", m.AuthMiddleware(), func(c *gin.Context) {
+			user := controllers.UserController{}
+			user.CancelDeletion(c)
+		})
+
+		userRouter.POST("/:id/contact/verify", m.AuthMiddleware(), func(c *gin.Context) {
+			user := controllers.UserController{}
+			user.VerifyContact(c)
+		})
+
+		userRouter.POST("/:id/exports", m.AuthMiddleware(), func(c *gin.Context) {
+			user := controllers.UserController{}
+			user.PostExport(c)
+		})
+
+		userRouter.GET("/:id/exports", m.AuthMiddleware(), func(c *gin.Context) {
+			user := controllers.UserController{}
+			user.GetExports(c)
+		})
+
+		// signed link, no token needed
+		userRouter.GET("/:id/exports/:eid/download", func(c *gin.Context) {
+			user := controllers.UserController{}
+			user.DownloadExport(c)
+		})
+
+		userRouter.POST("/:id/follow", m.AuthMiddleware(), func(c *gin.Context) {
+			user := controllers.UserController{}
+			user.PostFollow(c)
+		})
+
+		userRouter.DELETE("/:id/follow", m.AuthMiddleware(), func(c *gin.Context) {
+			user := controllers.UserController{}
+			user.DeleteFollow(c)
+		})
+
+		userRouter.GET("/:id/followers", m.AuthMiddleware(), func(c *gin.Context) {
+			user := controllers.UserController{}
+			user.GetFollowers(c)
+		})
+
+		userRouter.GET("/:id/following", m.AuthMiddleware(), func(c *gin.Context) {
+			user := controllers.UserController{}
+			user.GetFollowing(c)
+		})
+
+		userRouter.GET("/:id/follow-stats", m.AuthMiddleware(), func(c *gin.Context) {
+			user := controllers.UserController{}
+			user.GetFollowStats(c)
+		})
+
 		userRouter.POST("/login", func(c *gin.Context) {
 			user := controllers.UserController{}
 			user.Login(c)
 		})
+		userRouter.POST("/password-reset/code", func(c *gin.Context) {
+			user := controllers.UserController{}
+			user.PostResetCode(c)
+		})
+		userRouter.POST("/password-reset", func(c *gin.Context) {
+			user := controllers.UserController{}
+			user.ResetPassword(c)
+		})
 		userRouter.GET("/captcha", controllers.GenerateCaptcha)
 		userRouter.GET("/sms", controllers.SendSms)
 	}
@@ -0,0 +1,47 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"hammer-web-api/controllers"
+	m "hammer-web-api/middleware"
+)
+
+func InitWebhookRouter(rg *gin.RouterGroup) {
+	webhookRouter := rg.Group("webhooks")
+	{
+		webhookRouter.POST("", m.AuthMiddleware(), func(c *gin.Context) {
+			webhook := controllers.WebhookController{}
+			webhook.Post(c)
+		})
+
+		webhookRouter.GET("", m.AuthMiddleware(), func(c *gin.Context) {
+			webhook := controllers.WebhookController{}
+			webhook.GetList(c)
+		})
+
+		webhookRouter.GET("/:id", m.AuthMiddleware(), func(c *gin.Context) {
+			webhook := controllers.WebhookController{}
+			webhook.Get(c)
+		})
+
+		webhookRouter.PUT("/:id", m.AuthMiddleware(), func(c *gin.Context) {
+			webhook := controllers.WebhookController{}
+			webhook.Put(c)
+		})
+
+		webhookRouter.DELETE("/:id", m.AuthMiddleware(), func(c *gin.Context) {
+			webhook := controllers.WebhookController{}
+			webhook.Delete(c)
+		})
+
+		webhookRouter.GET("/:id/deliveries", m.AuthMiddleware(), func(c *gin.Context) {
+			webhook := controllers.WebhookController{}
+			webhook.GetDeliveries(c)
+		})
+
+		webhookRouter.POST("/:id/deliveries/:did/redeliver", m.AuthMiddleware(), func(c *gin.Context) {
+			webhook := controllers.WebhookController{}
+			webhook.Redeliver(c)
+		})
+	}
+}